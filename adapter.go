@@ -15,13 +15,13 @@ package main
 
 // NOTE: you do not need to edit this file when implementing a custom sd.
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -35,81 +35,248 @@ type customSD struct {
 }
 
 // Adapter runs an unknown service discovery implementation and converts its target groups
-// to JSON and writes to a file for file_sd.
+// to JSON and writes them to the outputs (eg a file for file_sd).
 type Adapter struct {
-	ctx     context.Context
-	disc    discovery.Discoverer
+	ctx  context.Context
+	disc discovery.Discoverer
+	// extra are the additional discoverers whose targets are merged with disc's.
+	extra   map[string]discovery.Discoverer
 	groups  map[string]*customSD
+	outputs []output
+	// written holds the last content successfully written to each output.
+	written map[string][]byte
 	manager *discovery.Manager
-	output  string
 	name    string
-	logger  log.Logger
+	leader  *leaderElector
+	// groupByTag is the tag key whose values group the targets (disabled if empty).
+	groupByTag string
+	// static are the targets added to the discovered ones (optional).
+	static *staticTargets
+	logger log.Logger
 }
 
+// sortKey returns a key identifying the content of the group.
+func (c *customSD) sortKey() string {
+	names := make([]string, 0, len(c.Labels))
+	for name := range c.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := make([]string, 0, len(names))
+	for _, name := range names {
+		labels = append(labels, name+"="+c.Labels[name])
+	}
+	return strings.Join(c.Targets, ",") + "|" + strings.Join(labels, ",")
+}
+
+// Returns the groups sorted by content so that the same targets always
+// produce the same output.
 func mapToArray(m map[string]*customSD) []customSD {
 	arr := make([]customSD, 0, len(m))
+	keys := make([]string, 0, len(m))
 	for _, v := range m {
 		arr = append(arr, *v)
+		keys = append(keys, v.sortKey())
 	}
+	// The keys are computed once rather than at every comparison.
+	sort.Sort(byKey{arr: arr, keys: keys})
 	return arr
 }
 
+// byKey sorts the groups by their precomputed sort keys.
+type byKey struct {
+	arr  []customSD
+	keys []string
+}
+
+func (b byKey) Len() int           { return len(b.arr) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.arr[i], b.arr[j] = b.arr[j], b.arr[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
+
+// Returns the groups written to the outputs.
+func (a *Adapter) outputGroups() []customSD {
+	arr := mapToArray(a.groups)
+	if a.groupByTag != "" {
+		arr = groupTargetsByTag(arr, a.groupByTag)
+	}
+	return a.static.merge(arr)
+}
+
+func marshalGroups(arr []customSD) []byte {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	encodeGroups(buf, arr)
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+// Encodes the groups like json.MarshalIndent.
+func encodeGroups(buf *bytes.Buffer, arr []customSD) {
+	enc := json.NewEncoder(buf)
+	enc.SetIndent("", "    ")
+	enc.Encode(arr)
+	// Remove the newline added by the encoder.
+	buf.Truncate(buf.Len() - 1)
+}
+
+// Loads the targets from an existing output so that they are available
+// before the first update and an identical update doesn't rewrite the output.
+func (a *Adapter) loadOutput() {
+	logger := log.With(a.logger, "component", "sd-adapter")
+	if len(a.groups) > 0 {
+		// The targets have already been written by WriteOnce.
+		return
+	}
+	for _, o := range a.outputs {
+		l, ok := o.(loader)
+		if !ok {
+			continue
+		}
+		b, err := l.Load()
+		if err != nil {
+			level.Warn(logger).Log("msg", "failed to read existing output", "output", o.Name(), "err", err)
+			continue
+		}
+		if b == nil {
+			continue
+		}
+		var arr []customSD
+		if err := json.Unmarshal(b, &arr); err != nil {
+			level.Warn(logger).Log("msg", "failed to parse existing output", "output", o.Name(), "err", err)
+			continue
+		}
+		for i := range arr {
+			a.groups[fmt.Sprintf("%s:warm:%d", a.name, i)] = &arr[i]
+		}
+		a.written[o.Name()] = marshalGroups(a.outputGroups())
+		level.Info(logger).Log("msg", "loaded existing output", "output", o.Name(), "groups", len(arr))
+
+		// Make the loaded targets available to the other outputs.
+		a.refreshOutput()
+		return
+	}
+}
+
+// Converts a target group to its file_sd representation.
+func toCustomSD(group *targetgroup.Group) *customSD {
+	newTargets := make([]string, 0)
+	newLabels := getLabels()
+
+	for _, targets := range group.Targets {
+		for _, target := range targets {
+			newTargets = append(newTargets, string(target))
+		}
+	}
+
+	for name, value := range group.Labels {
+		newLabels[string(name)] = string(value)
+	}
+	return &customSD{
+		Targets: newTargets,
+		Labels:  newLabels,
+	}
+}
+
 // Parses incoming target groups updates. If the update contains changes to the target groups
-// Adapter already knows about, or new target groups, we Marshal to JSON and write to file.
+// Adapter already knows about, or new target groups, we Marshal to JSON and write to the outputs.
+// Outputs which failed previously are retried even if nothing changed.
 func (a *Adapter) generateTargetGroups(allTargetGroups map[string][]*targetgroup.Group) {
+	a.updateGroups(allTargetGroups)
+	a.refreshOutput()
+}
+
+// Converts the target groups and returns true if they differ from the known groups.
+func (a *Adapter) updateGroups(allTargetGroups map[string][]*targetgroup.Group) bool {
 	tempGroups := make(map[string]*customSD)
 	for k, sdTargetGroups := range allTargetGroups {
+		var n int
 		for i, group := range sdTargetGroups {
-			newTargets := make([]string, 0)
-			newLabels := make(map[string]string)
-
-			for _, targets := range group.Targets {
-				for _, target := range targets {
-					newTargets = append(newTargets, string(target))
-				}
-			}
-
-			for name, value := range group.Labels {
-				newLabels[string(name)] = string(value)
-			}
+			n += len(group.Targets)
 			// Make a unique key, including the current index, in case the sd_type (map key) and group.Source is not unique.
 			key := fmt.Sprintf("%s:%s:%d", k, group.Source, i)
-			tempGroups[key] = &customSD{
-				Targets: newTargets,
-				Labels:  newLabels,
-			}
+			tempGroups[key] = toCustomSD(group)
 		}
+		sdDiscoveredTargets.WithLabelValues(a.name, k).Set(float64(n))
 	}
-	if !reflect.DeepEqual(a.groups, tempGroups) {
-		a.groups = tempGroups
-		err := a.writeOutput()
-		if err != nil {
-			level.Error(log.With(a.logger, "component", "sd-adapter")).Log("err", err)
-		}
+	if reflect.DeepEqual(a.groups, tempGroups) {
+		releaseLabels(tempGroups)
+		return false
 	}
-
+	releaseLabels(a.groups)
+	a.groups = tempGroups
+	return true
 }
 
-// Writes JSON formatted targets to output file.
-func (a *Adapter) writeOutput() error {
-	arr := mapToArray(a.groups)
-	b, _ := json.MarshalIndent(arr, "", "    ")
-
-	dir, _ := filepath.Split(a.output)
-	tmpfile, err := ioutil.TempFile(dir, "sd-adapter")
-	if err != nil {
-		return err
+// Writes the outputs. The outputs shared by the replicas are only written
+// by the leader.
+func (a *Adapter) refreshOutput() {
+	leader := a.leader.IsLeader()
+	if !leader {
+		level.Debug(log.With(a.logger, "component", "sd-adapter")).Log("msg", "not the leader, only writing the local outputs")
 	}
-	defer tmpfile.Close()
-
-	_, err = tmpfile.Write(b)
+	err := a.writeOutput(leader)
 	if err != nil {
-		return err
+		level.Error(log.With(a.logger, "component", "sd-adapter")).Log("err", err)
 	}
+}
 
-	err = os.Rename(tmpfile.Name(), a.output)
-	if err != nil {
-		return err
+// Writes JSON formatted targets to the outputs which aren't up-to-date.
+// Only the local outputs are written if shared is false.
+func (a *Adapter) writeOutput(shared bool) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	encodeGroups(buf, a.outputGroups())
+
+	var (
+		failed []string
+		// b is a copy of the buffer, made only when an output is written.
+		b []byte
+		// discovered are the discovered groups and db their JSON, made only
+		// when an output needs them.
+		discovered []customSD
+		db         []byte
+	)
+	for _, o := range a.outputs {
+		if !shared {
+			if l, ok := o.(localOutput); !ok || !l.Local() {
+				continue
+			}
+		}
+		content := buf.Bytes()
+		g, isGroups := o.(groupsOutput)
+		if isGroups {
+			if db == nil {
+				discovered = mapToArray(a.groups)
+				db = marshalGroups(discovered)
+			}
+			content = db
+		}
+		if bytes.Equal(content, a.written[o.Name()]) {
+			continue
+		}
+		var err error
+		if isGroups {
+			err = g.WriteGroups(discovered)
+		} else {
+			if b == nil {
+				b = append([]byte(nil), buf.Bytes()...)
+			}
+			content = b
+			err = o.Write(b)
+		}
+		outputWrites.WithLabelValues(o.Name()).Inc()
+		if err != nil {
+			outputFailures.WithLabelValues(o.Name()).Inc()
+			level.Error(log.With(a.logger, "component", "sd-adapter")).Log("msg", "failed to write output", "output", o.Name(), "err", err)
+			failed = append(failed, o.Name())
+			continue
+		}
+		a.written[o.Name()] = content
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to write %d output(s): %s", len(failed), strings.Join(failed, ", "))
 	}
 	return nil
 }
@@ -125,27 +292,63 @@ func (a *Adapter) runCustomSD(ctx context.Context) {
 			if !ok {
 				return
 			}
+			sdUpdates.WithLabelValues(a.name).Inc()
 			a.generateTargetGroups(allTargetGroups)
+		case <-a.leader.Elected():
+			// Write the latest targets as soon as the leadership is acquired
+			// since the previous leader may have written something else.
+			a.written = make(map[string][]byte)
+			a.refreshOutput()
 		}
 	}
 }
 
 // Run starts a Discovery Manager and the custom service discovery implementation.
 func (a *Adapter) Run() {
+	a.loadOutput()
 	go a.manager.Run()
+	if a.leader != nil {
+		go a.leader.Run(a.ctx)
+	}
 	a.manager.StartCustomProvider(a.ctx, a.name, a.disc)
+	for name, d := range a.extra {
+		a.manager.StartCustomProvider(a.ctx, name, d)
+	}
 	go a.runCustomSD(a.ctx)
 }
 
+// WriteOnce converts the target groups of a single discovery pass and writes them to the outputs.
+func (a *Adapter) WriteOnce(tgs []*targetgroup.Group) error {
+	a.updateGroups(map[string][]*targetgroup.Group{a.name: tgs})
+	return a.writeOutput(true)
+}
+
+// Groups returns the groups which WriteOnce would write for the target
+// groups, without writing the outputs.
+func (a *Adapter) Groups(tgs []*targetgroup.Group) []customSD {
+	a.updateGroups(map[string][]*targetgroup.Group{a.name: tgs})
+	return a.outputGroups()
+}
+
+// AddDiscoverer adds a discoverer whose targets are written along with the
+// main discoverer's. It must be called before Run.
+func (a *Adapter) AddDiscoverer(name string, d discovery.Discoverer) {
+	a.extra[name] = d
+}
+
 // NewAdapter creates a new instance of Adapter.
-func NewAdapter(ctx context.Context, file string, name string, d discovery.Discoverer, logger log.Logger) *Adapter {
+// The leader elector is optional: when nil, the adapter always writes the outputs.
+func NewAdapter(ctx context.Context, outputs []output, name string, d discovery.Discoverer, leader *leaderElector, logger log.Logger) *Adapter {
 	return &Adapter{
 		ctx:     ctx,
 		disc:    d,
+		extra:   make(map[string]discovery.Discoverer),
 		groups:  make(map[string]*customSD),
+		outputs: outputs,
+		written: make(map[string][]byte),
 		manager: discovery.NewManager(ctx, logger),
-		output:  file,
 		name:    name,
+		leader:  leader,
 		logger:  logger,
 	}
 }
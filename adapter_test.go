@@ -0,0 +1,98 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// memoryOutput records the last targets written.
+type memoryOutput struct {
+	name    string
+	content []byte
+}
+
+func (m *memoryOutput) Name() string { return m.name }
+
+func (m *memoryOutput) Write(b []byte) error {
+	m.content = b
+	return nil
+}
+
+func testGroup(source, addr string, labels model.LabelSet) *targetgroup.Group {
+	return &targetgroup.Group{
+		Source:  source,
+		Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(addr)}},
+		Labels:  labels,
+	}
+}
+
+func TestRefreshOutputFollower(t *testing.T) {
+	shared := &memoryOutput{name: "shared"}
+	local := newHTTPOutput()
+	leader := &leaderElector{}
+	a := NewAdapter(context.Background(), []output{shared, local}, "scw", nil, leader, log.NewNopLogger())
+
+	a.generateTargetGroups(map[string][]*targetgroup.Group{
+		"scw": {testGroup("web-1", "10.0.0.1:80", nil)},
+	})
+	if shared.content != nil {
+		t.Errorf("expected the follower not to write the shared output, got %s", shared.content)
+	}
+	rec := httptest.NewRecorder()
+	local.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), "10.0.0.1:80") {
+		t.Errorf("expected the follower to serve its targets, got %s", rec.Body.String())
+	}
+
+	leader.setLeader(true)
+	a.refreshOutput()
+	if !strings.Contains(string(shared.content), "10.0.0.1:80") {
+		t.Errorf("expected the leader to write the shared output, got %s", shared.content)
+	}
+}
+
+// BenchmarkUnchangedUpdate measures an update of the adapter with the same
+// target groups as the previous one, which is the common case at every
+// refresh. Run it with:
+//
+//	go test -run=- -bench=UnchangedUpdate -benchmem .
+func BenchmarkUnchangedUpdate(b *testing.B) {
+	groups := make([]*targetgroup.Group, 3000)
+	for i := range groups {
+		addr := fmt.Sprintf("10.0.%d.%d:80", i/256, i%256)
+		labels := model.LabelSet{model.AddressLabel: model.LabelValue(addr)}
+		for j := 0; len(labels) < 25; j++ {
+			labels[model.LabelName(fmt.Sprintf("%slabel_%d", scwPrefix, j))] = model.LabelValue(fmt.Sprintf("value-%d-%d", i, j))
+		}
+		groups[i] = testGroup(fmt.Sprintf("scaleway/server-%d", i), addr, labels)
+	}
+	tgs := map[string][]*targetgroup.Group{"scw": groups}
+	a := NewAdapter(context.Background(), []output{&memoryOutput{name: "file"}}, "scw", nil, nil, log.NewNopLogger())
+	a.generateTargetGroups(tgs)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.generateTargetGroups(tgs)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/scaleway/go-scaleway/types"
+)
+
+// AddressSelector decides the scrape address of a server.
+type AddressSelector interface {
+	// Address returns the address (host:port) scraping the given port of
+	// the server. An error excludes the server from the targets.
+	Address(srv *types.ScalewayServer, port int) (string, error)
+}
+
+// AddressSelectorFunc is a function implementing the AddressSelector interface.
+type AddressSelectorFunc func(srv *types.ScalewayServer, port int) (string, error)
+
+// Address implements the AddressSelector interface.
+func (f AddressSelectorFunc) Address(srv *types.ScalewayServer, port int) (string, error) {
+	return f(srv, port)
+}
+
+// addressSelectors are the selectors available to --target.address.
+var addressSelectors = map[string]AddressSelector{
+	"private": hostSelector("private IP", func(srv *types.ScalewayServer) string {
+		return srv.PrivateIP
+	}),
+	"public": hostSelector("public IP", func(srv *types.ScalewayServer) string {
+		return srv.PublicAddress.IP
+	}),
+	"public-or-private": hostSelector("IP", func(srv *types.ScalewayServer) string {
+		if srv.PublicAddress.IP != "" {
+			return srv.PublicAddress.IP
+		}
+		return srv.PrivateIP
+	}),
+	"private-dns": hostSelector("private DNS name", func(srv *types.ScalewayServer) string {
+		return srv.DNSPrivate
+	}),
+	"public-dns": hostSelector("public DNS name", func(srv *types.ScalewayServer) string {
+		return srv.DNSPublic
+	}),
+}
+
+// RegisterAddressSelector makes a selector available to --target.address.
+// It is meant to be called from an init function and panics if the name is
+// already registered.
+func RegisterAddressSelector(name string, s AddressSelector) {
+	if _, ok := addressSelectors[name]; ok {
+		panic(fmt.Sprintf("address selector %q already registered", name))
+	}
+	addressSelectors[name] = s
+}
+
+// getAddressSelector returns the selector registered with the name.
+func getAddressSelector(name string) (AddressSelector, error) {
+	s, ok := addressSelectors[name]
+	if !ok {
+		names := make([]string, 0, len(addressSelectors))
+		for n := range addressSelectors {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown address selector %q (available: %v)", name, names)
+	}
+	return s, nil
+}
+
+// hostSelector returns a selector joining the host returned by the function
+// with the port.
+func hostSelector(desc string, host func(srv *types.ScalewayServer) string) AddressSelector {
+	return AddressSelectorFunc(func(srv *types.ScalewayServer, port int) (string, error) {
+		h := host(srv)
+		if h == "" {
+			return "", fmt.Errorf("server has no %s", desc)
+		}
+		return net.JoinHostPort(h, strconv.Itoa(port)), nil
+	})
+}
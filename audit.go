@@ -0,0 +1,186 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+// auditServer identifies a server in the audit log.
+type auditServer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Rule is the filter rule which excluded the server.
+	Rule string `json:"rule,omitempty"`
+}
+
+// auditRecord holds the decisions taken during the refreshes since the
+// previous record. A decision taken again by several refreshes is only
+// recorded once. A nil record ignores the decisions.
+type auditRecord struct {
+	Time time.Time `json:"time"`
+	// Since is the time of the first refresh of the record.
+	Since     time.Time     `json:"since"`
+	Refreshes int           `json:"refreshes"`
+	Seen      []auditServer `json:"seen"`
+	Filtered  []auditServer `json:"filtered"`
+	Pending   []string      `json:"pending"`
+	Emitted   []string      `json:"emitted"`
+	Removed   []string      `json:"removed"`
+	// decisions are the decisions already recorded.
+	decisions map[string]struct{}
+}
+
+func newAuditRecord(now time.Time) *auditRecord {
+	return &auditRecord{Since: now.UTC(), decisions: make(map[string]struct{})}
+}
+
+// add returns true if the decision isn't recorded yet.
+func (r *auditRecord) add(decision ...string) bool {
+	k := strings.Join(decision, "/")
+	if _, ok := r.decisions[k]; ok {
+		return false
+	}
+	r.decisions[k] = struct{}{}
+	return true
+}
+
+func (r *auditRecord) seen(srv *types.ScalewayServer) {
+	if r != nil && r.add("seen", srv.Identifier) {
+		r.Seen = append(r.Seen, auditServer{ID: srv.Identifier, Name: srv.Name})
+	}
+}
+
+func (r *auditRecord) filtered(srv *types.ScalewayServer, rule string) {
+	if r != nil && r.add("filtered", srv.Identifier, rule) {
+		r.Filtered = append(r.Filtered, auditServer{ID: srv.Identifier, Name: srv.Name, Rule: rule})
+	}
+}
+
+func (r *auditRecord) pending(source string) {
+	if r != nil && r.add("pending", source) {
+		r.Pending = append(r.Pending, source)
+	}
+}
+
+func (r *auditRecord) emitted(source string) {
+	if r != nil && r.add("emitted", source) {
+		r.Emitted = append(r.Emitted, source)
+	}
+}
+
+func (r *auditRecord) removed(source string) {
+	if r != nil && r.add("removed", source) {
+		r.Removed = append(r.Removed, source)
+	}
+}
+
+// auditLog writes the decisions of the refreshes to a file as JSON lines, at
+// most once per interval: the decisions of the refreshes in between are
+// accumulated in the next record. The file is rotated to a single backup
+// (with the ".1" suffix) when it exceeds the maximum size.
+type auditLog struct {
+	path        string
+	maxSize     int64
+	minInterval time.Duration
+	last        time.Time
+	// current accumulates the decisions until the next write.
+	current *auditRecord
+	logger  log.Logger
+}
+
+// newAuditLog returns an audit log or nil if the path is empty.
+func newAuditLog(path string, maxSize int64, minInterval time.Duration, logger log.Logger) *auditLog {
+	if path == "" {
+		return nil
+	}
+	return &auditLog{
+		path:        path,
+		maxSize:     maxSize,
+		minInterval: minInterval,
+		logger:      log.With(logger, "component", "audit"),
+	}
+}
+
+// record returns the record of a new refresh or nil if the audit log is
+// disabled. The record is shared with the previous refreshes until it is
+// written.
+func (a *auditLog) record() *auditRecord {
+	if a == nil {
+		return nil
+	}
+	if a.current == nil {
+		a.current = newAuditRecord(time.Now())
+	}
+	a.current.Refreshes++
+	return a.current
+}
+
+// write appends the record to the file unless the previous record is too
+// recent, in which case the decisions are kept for the next write.
+func (a *auditLog) write(r *auditRecord) {
+	if a == nil || r == nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(a.last) < a.minInterval {
+		return
+	}
+	r.Time = now.UTC()
+	b, err := json.Marshal(r)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to encode the audit record", "err", err)
+		return
+	}
+	if err := a.rotate(); err != nil {
+		level.Error(a.logger).Log("msg", "failed to rotate the audit log", "err", err)
+	}
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to open the audit log", "err", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		level.Error(a.logger).Log("msg", "failed to write the audit log", "err", err)
+		return
+	}
+	a.last = now
+	a.current = nil
+}
+
+// rotate renames the file to the backup if it exceeds the maximum size.
+func (a *auditLog) rotate() error {
+	if a.maxSize <= 0 {
+		return nil
+	}
+	fi, err := os.Stat(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Size() < a.maxSize {
+		return nil
+	}
+	return os.Rename(a.path, a.path+".1")
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestAuditLogAccumulate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prometheus-scw-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	a := newAuditLog(path, 0, time.Hour, log.NewNopLogger())
+	web, db := testServer("web-1", "par1", "running", "10.0.0.1"), testServer("db-1", "par1", "running", "10.0.0.3")
+
+	// The first refresh is written immediately.
+	rec := a.record()
+	rec.seen(&web)
+	rec.emitted("scaleway/web-1")
+	a.write(rec)
+
+	// The next refreshes are accumulated until the interval has elapsed.
+	rec = a.record()
+	rec.seen(&web)
+	rec.seen(&db)
+	rec.filtered(&db, "exclude-tags")
+	rec.emitted("scaleway/web-1")
+	a.write(rec)
+	rec = a.record()
+	rec.seen(&db)
+	rec.filtered(&db, "exclude-tags")
+	rec.removed("scaleway/web-1")
+	a.write(rec)
+	a.last = a.last.Add(-time.Hour)
+	a.write(a.record())
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []auditRecord
+	for s := bufio.NewScanner(f); s.Scan(); {
+		var r auditRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	r := records[1]
+	if r.Refreshes != 3 {
+		t.Errorf("expected 3 refreshes in the second record, got %d", r.Refreshes)
+	}
+	if r.Since.Before(records[0].Time) || r.Since.After(r.Time) {
+		t.Errorf("expected the second record to start after the first one, got %v-%v", r.Since, r.Time)
+	}
+	for name, tc := range map[string]struct{ got, want interface{} }{
+		"seen":     {r.Seen, []auditServer{{ID: "web-1", Name: "web-1"}, {ID: "db-1", Name: "db-1"}}},
+		"filtered": {r.Filtered, []auditServer{{ID: "db-1", Name: "db-1", Rule: "exclude-tags"}}},
+		"emitted":  {r.Emitted, []string{"scaleway/web-1"}},
+		"removed":  {r.Removed, []string{"scaleway/web-1"}},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, tc.got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+// serverCacheMaxAge bounds the age of a cached target group so that the
+// changes which don't update the modification date of the server (eg its
+// user_data or its DNS name) are eventually picked up.
+const serverCacheMaxAge = time.Hour
+
+var serverCacheLookups = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "prometheus_scaleway_sd_server_cache_lookups_total",
+		Help: "Total number of lookups of the servers' target groups in the cache.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	reg.MustRegister(serverCacheLookups)
+}
+
+type cachedServer struct {
+	modified string
+	state    string
+	orgName  string
+	addr     string
+	created  time.Time
+	group    *targetgroup.Group
+}
+
+// serverCache holds the target group of every server until the server's
+// modification date changes, so that the unchanged servers aren't processed
+// again at every refresh.
+type serverCache struct {
+	entries map[string]*cachedServer
+}
+
+func newServerCache() *serverCache {
+	return &serverCache{entries: make(map[string]*cachedServer)}
+}
+
+// get returns the cached target group of the server or nil if the server or
+// its address have changed since it was cached. The address can change
+// without the server being modified, eg in a Private Network.
+func (c *serverCache) get(srv *types.ScalewayServer, orgName, addr string) *targetgroup.Group {
+	e, ok := c.entries[srv.Identifier]
+	if !ok || srv.ModificationDate == "" || e.modified != srv.ModificationDate || e.state != srv.State || e.orgName != orgName || e.addr != addr || time.Since(e.created) > serverCacheMaxAge {
+		serverCacheLookups.WithLabelValues("miss").Inc()
+		return nil
+	}
+	serverCacheLookups.WithLabelValues("hit").Inc()
+	return e.group
+}
+
+// set caches the target group of the server. The group must not be modified afterwards.
+func (c *serverCache) set(srv *types.ScalewayServer, orgName, addr string, tg *targetgroup.Group) {
+	c.entries[srv.Identifier] = &cachedServer{
+		modified: srv.ModificationDate,
+		state:    srv.State,
+		orgName:  orgName,
+		addr:     addr,
+		created:  time.Now(),
+		group:    tg,
+	}
+}
+
+// prune removes the servers which aren't returned by the API anymore.
+func (c *serverCache) prune(current map[string]struct{}) {
+	for id := range c.entries {
+		if _, ok := current[id]; !ok {
+			delete(c.entries, id)
+		}
+	}
+}
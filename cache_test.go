@@ -0,0 +1,47 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func TestServerCache(t *testing.T) {
+	c := newServerCache()
+	srv := testServer("web-1", "par1", "running", "10.0.0.1")
+	tg := &targetgroup.Group{Source: "scaleway/web-1"}
+	c.set(&srv, "acme", "172.16.0.1:80", tg)
+
+	if got := c.get(&srv, "acme", "172.16.0.1:80"); got != tg {
+		t.Errorf("expected a hit, got %v", got)
+	}
+	// The address can change without the server being modified.
+	if got := c.get(&srv, "acme", "172.16.0.2:80"); got != nil {
+		t.Errorf("expected a miss for a new address, got %v", got)
+	}
+	if got := c.get(&srv, "other", "172.16.0.1:80"); got != nil {
+		t.Errorf("expected a miss for a new organization name, got %v", got)
+	}
+	srv.ModificationDate = "2018-05-02T10:00:00Z"
+	if got := c.get(&srv, "acme", "172.16.0.1:80"); got != nil {
+		t.Errorf("expected a miss for a modified server, got %v", got)
+	}
+
+	c.prune(map[string]struct{}{})
+	if len(c.entries) != 0 {
+		t.Errorf("expected the cache to be empty, got %d entries", len(c.entries))
+	}
+}
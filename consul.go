@@ -0,0 +1,134 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/prometheus/common/model"
+)
+
+// consulExternalSource is the node metadata identifying the nodes registered by the service discovery.
+const consulExternalSource = "prometheus-scw-sd"
+
+// consulOutput registers the targets as external services in the Consul
+// catalog, which is also the service catalog used by Nomad when integrated
+// with Consul.
+type consulOutput struct {
+	client  *consul.Client
+	service string
+}
+
+func newConsulOutput(addr, service string) (*consulOutput, error) {
+	cfg := consul.DefaultConfig()
+	cfg.Address = addr
+	client, err := consul.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &consulOutput{client: client, service: service}, nil
+}
+
+// Name implements the output interface.
+func (c *consulOutput) Name() string {
+	return "consul"
+}
+
+// registration returns the catalog registration of a target of a group.
+func (c *consulOutput) registration(g customSD, target string) (*consul.CatalogRegistration, error) {
+	host, p, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return nil, err
+	}
+	// The groups merging several servers don't identify a single server.
+	id := g.Labels[identifierLabel]
+	if id == "" || len(g.Targets) > 1 {
+		id = host
+	}
+
+	meta := map[string]string{"external-source": consulExternalSource}
+	for name, value := range g.Labels {
+		if !strings.HasPrefix(name, scwPrefix) || name == tagsLabel || value == "" {
+			continue
+		}
+		meta[strings.TrimPrefix(name, model.MetaLabelPrefix)] = value
+	}
+	var tags []string
+	if t := strings.Trim(g.Labels[tagsLabel], ","); t != "" {
+		tags = strings.Split(t, ",")
+	}
+
+	return &consul.CatalogRegistration{
+		Node:     "scaleway-" + id,
+		Address:  host,
+		NodeMeta: meta,
+		Service: &consul.AgentService{
+			ID:      c.service + "-" + id + "-" + p,
+			Service: c.service,
+			Tags:    tags,
+			Address: host,
+			Port:    port,
+		},
+	}, nil
+}
+
+// Write implements the output interface.
+func (c *consulOutput) Write(b []byte) error {
+	var groups []customSD
+	if err := json.Unmarshal(b, &groups); err != nil {
+		return err
+	}
+
+	catalog := c.client.Catalog()
+	current := make(map[string]struct{}, len(groups))
+	for _, g := range groups {
+		for _, t := range g.Targets {
+			r, err := c.registration(g, t)
+			if err != nil {
+				return err
+			}
+			if _, err := catalog.Register(r, nil); err != nil {
+				return fmt.Errorf("failed to register %s: %v", r.Node, err)
+			}
+			current[r.Node] = struct{}{}
+		}
+	}
+
+	// Deregister the nodes which aren't discovered anymore.
+	entries, _, err := catalog.Service(c.service, "", nil)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.NodeMeta["external-source"] != consulExternalSource {
+			continue
+		}
+		if _, ok := current[e.Node]; ok {
+			continue
+		}
+		if _, err := catalog.Deregister(&consul.CatalogDeregistration{Node: e.Node}, nil); err != nil {
+			return fmt.Errorf("failed to deregister %s: %v", e.Node, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// vaultTimeout is the timeout of the requests to Vault.
+const vaultTimeout = 10 * time.Second
+
+// CredentialsProvider provides the Scaleway token. The token is requested
+// at every refresh so that the rotated tokens are picked up.
+type CredentialsProvider interface {
+	// Token returns the current token.
+	Token() (string, error)
+}
+
+// CredentialsProviderFunc is a function implementing the CredentialsProvider interface.
+type CredentialsProviderFunc func() (string, error)
+
+// Token implements the CredentialsProvider interface.
+func (f CredentialsProviderFunc) Token() (string, error) {
+	return f()
+}
+
+// credentialsProviders are the providers available to --scw.credentials.
+// They are built once the command-line flags are parsed.
+var credentialsProviders = map[string]func() (CredentialsProvider, error){
+	"file":   newFileCredentials,
+	"env":    newEnvCredentials,
+	"static": newStaticCredentials,
+	"vault":  newVaultCredentials,
+}
+
+// RegisterCredentialsProvider makes a provider available to --scw.credentials.
+// It is meant to be called from an init function and panics if the name is
+// already registered.
+func RegisterCredentialsProvider(name string, newProvider func() (CredentialsProvider, error)) {
+	if _, ok := credentialsProviders[name]; ok {
+		panic(fmt.Sprintf("credentials provider %q already registered", name))
+	}
+	credentialsProviders[name] = newProvider
+}
+
+// getCredentialsProvider returns the provider registered with the name.
+func getCredentialsProvider(name string) (CredentialsProvider, error) {
+	newProvider, ok := credentialsProviders[name]
+	if !ok {
+		names := make([]string, 0, len(credentialsProviders))
+		for n := range credentialsProviders {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown credentials provider %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return newProvider()
+}
+
+// newFileCredentials reads the token from the --scw.token-file file.
+func newFileCredentials() (CredentialsProvider, error) {
+	if *tokenf == "" {
+		return nil, fmt.Errorf("need to pass --scw.token-file")
+	}
+	path := *tokenf
+	return CredentialsProviderFunc(func() (string, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}), nil
+}
+
+// newEnvCredentials reads the token from the --scw.token-env environment variable.
+func newEnvCredentials() (CredentialsProvider, error) {
+	name := *tokenEnv
+	return CredentialsProviderFunc(func() (string, error) {
+		token := strings.TrimSpace(os.Getenv(name))
+		if token == "" {
+			return "", fmt.Errorf("the %s environment variable is empty", name)
+		}
+		return token, nil
+	}), nil
+}
+
+// newStaticCredentials returns the token of the --scw.token flag.
+func newStaticCredentials() (CredentialsProvider, error) {
+	if *staticToken == "" {
+		return nil, fmt.Errorf("need to pass --scw.token")
+	}
+	t := *staticToken
+	return CredentialsProviderFunc(func() (string, error) {
+		return t, nil
+	}), nil
+}
+
+// vaultCredentials reads the token from a secret of a Vault KV engine.
+type vaultCredentials struct {
+	url   string
+	field string
+	// tokenFile is the file of the Vault token, VAULT_TOKEN is used if empty.
+	tokenFile string
+	client    *http.Client
+}
+
+func newVaultCredentials() (CredentialsProvider, error) {
+	addr := *vaultAddr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("need to pass --scw.vault.address or to set VAULT_ADDR")
+	}
+	if *vaultPath == "" {
+		return nil, fmt.Errorf("need to pass --scw.vault.path")
+	}
+	return &vaultCredentials{
+		url:       fmt.Sprintf("%s/v1/%s", strings.TrimRight(addr, "/"), strings.TrimLeft(*vaultPath, "/")),
+		field:     *vaultField,
+		tokenFile: *vaultTokenFile,
+		client:    &http.Client{Timeout: vaultTimeout},
+	}, nil
+}
+
+// vaultToken returns the token authenticating to Vault.
+func (v *vaultCredentials) vaultToken() (string, error) {
+	if v.tokenFile == "" {
+		if t := os.Getenv("VAULT_TOKEN"); t != "" {
+			return t, nil
+		}
+		return "", fmt.Errorf("need to pass --scw.vault.token-file or to set VAULT_TOKEN")
+	}
+	b, err := ioutil.ReadFile(v.tokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Token implements the CredentialsProvider interface.
+func (v *vaultCredentials) Token() (string, error) {
+	vt, err := v.vaultToken()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, v.url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", vt)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status from Vault %s: %s", resp.Status, body)
+	}
+
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", err
+	}
+	data := secret.Data
+	// The version 2 of the KV engine nests the secret in another data field.
+	if raw, ok := data["data"]; ok && data["metadata"] != nil {
+		var nested map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &nested); err != nil {
+			return "", err
+		}
+		data = nested
+	}
+	var t string
+	if raw, ok := data[v.field]; ok {
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return "", fmt.Errorf("invalid field %q of the Vault secret: %v", v.field, err)
+		}
+	}
+	if t == "" {
+		return "", fmt.Errorf("no field %q in the Vault secret", v.field)
+	}
+	return t, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialsProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prometheus-scw-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte(" file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("SCW_SD_TEST_TOKEN", "env-token")
+	defer os.Unsetenv("SCW_SD_TEST_TOKEN")
+
+	for _, tc := range []struct {
+		args []string
+		want string
+		err  bool
+	}{
+		{args: []string{"--scw.token-file=" + tokenFile}, want: "file-token"},
+		{args: []string{"--scw.token-file=" + filepath.Join(dir, "missing")}, err: true},
+		{args: []string{"--scw.credentials=env", "--scw.token-env=SCW_SD_TEST_TOKEN"}, want: "env-token"},
+		{args: []string{"--scw.credentials=env", "--scw.token-env=SCW_SD_TEST_UNSET"}, err: true},
+		{args: []string{"--scw.credentials=static", "--scw.token=static-token"}, want: "static-token"},
+		{args: []string{"--scw.credentials=static"}, err: true},
+		{args: []string{"--scw.credentials=unknown"}, err: true},
+	} {
+		resetFlags()
+		if _, err := a.Parse(append([]string{"validate"}, tc.args...)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readToken()
+		if tc.err {
+			if err == nil {
+				t.Errorf("%v: expected an error, got token %q", tc.args, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%v: expected %q, got %q (err: %v)", tc.args, tc.want, got, err)
+		}
+	}
+}
+
+func TestVaultCredentials(t *testing.T) {
+	secrets := map[string]interface{}{
+		// KV version 1.
+		"/v1/secret/scaleway": map[string]interface{}{
+			"data": map[string]string{"token": "kv1-token"},
+		},
+		// KV version 2.
+		"/v1/kv/data/scaleway": map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]string{"token": "kv2-token"},
+				"metadata": map[string]int{"version": 3},
+			},
+		},
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		secret, ok := secrets[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(secret)
+	}))
+	defer s.Close()
+	os.Setenv("VAULT_TOKEN", "vault-token")
+	defer os.Unsetenv("VAULT_TOKEN")
+
+	for _, tc := range []struct {
+		path, field string
+		want        string
+	}{
+		{path: "secret/scaleway", field: "token", want: "kv1-token"},
+		{path: "kv/data/scaleway", field: "token", want: "kv2-token"},
+		{path: "kv/data/scaleway", field: "missing"},
+		{path: "secret/missing", field: "token"},
+	} {
+		resetFlags()
+		if _, err := a.Parse([]string{"validate", "--scw.credentials=vault", "--scw.vault.address=" + s.URL, "--scw.vault.path=" + tc.path, "--scw.vault.field=" + tc.field}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readToken()
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s/%s: expected an error, got token %q", tc.path, tc.field, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s/%s: expected %q, got %q (err: %v)", tc.path, tc.field, tc.want, got, err)
+		}
+	}
+}
+
+func TestRegisterCredentialsProvider(t *testing.T) {
+	RegisterCredentialsProvider("test", func() (CredentialsProvider, error) {
+		return CredentialsProviderFunc(func() (string, error) { return "test-token", nil }), nil
+	})
+	defer delete(credentialsProviders, "test")
+
+	p, err := getCredentialsProvider("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.Token(); err != nil || got != "test-token" {
+		t.Errorf("expected %q, got %q (err: %v)", "test-token", got, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a duplicate registration to panic")
+		}
+	}()
+	RegisterCredentialsProvider("test", nil)
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/go-kit/kit/log"
+	api "github.com/scaleway/go-scaleway"
+)
+
+// indexTargets returns the labels of the file_sd groups indexed by target address.
+func indexTargets(groups []customSD) map[string]map[string]string {
+	idx := make(map[string]map[string]string)
+	for _, g := range groups {
+		for _, t := range g.Targets {
+			idx[t] = g.Labels
+		}
+	}
+	return idx
+}
+
+// diffLabels prints the labels which differ between two label sets.
+func diffLabels(w io.Writer, old, new map[string]string) {
+	names := make(map[string]struct{})
+	for name := range old {
+		names[name] = struct{}{}
+	}
+	for name := range new {
+		names[name] = struct{}{}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		o, inOld := old[name]
+		n, inNew := new[name]
+		switch {
+		case !inOld:
+			fmt.Fprintf(w, "    + %s=%q\n", name, n)
+		case !inNew:
+			fmt.Fprintf(w, "    - %s=%q\n", name, o)
+		case o != n:
+			fmt.Fprintf(w, "    ~ %s=%q -> %q\n", name, o, n)
+		}
+	}
+}
+
+// runDiff performs one discovery pass and prints the targets which are
+// added, removed or changed compared to an existing file_sd file. The targets
+// are built like the once command writes them, including the grouping and the
+// static targets.
+func runDiff(client *api.ScalewayAPI, logger log.Logger, file string, w io.Writer) error {
+	// The file is read like the file output, from its chunks when split.
+	f := newFileOutput(file, 0, outputMaxTargets, outputMaxBytes)
+	b, err := f.Load()
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return fmt.Errorf("no targets in %s", f.pattern())
+	}
+	var existing []customSD
+	if err := json.Unmarshal(b, &existing); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", f.pattern(), err)
+	}
+
+	disc, err := newDiscoverer(client, logger)
+	if err != nil {
+		return err
+	}
+	tgs, err := collectTargets(disc, newIoTDiscoverer(client.Token, iotRegions, iotPort, *refresh, disc.filter.orgs, logger))
+	if err != nil {
+		return err
+	}
+	sdAdapter, err := newAdapter(context.Background(), nil, disc, nil, logger)
+	if err != nil {
+		return err
+	}
+	diffTargets(existing, sdAdapter.Groups(tgs), w)
+	return nil
+}
+
+// diffTargets prints the targets which are added, removed or changed between
+// two sets of file_sd groups.
+func diffTargets(existing, live []customSD, w io.Writer) {
+	before, after := indexTargets(existing), indexTargets(live)
+	addrs := make([]string, 0, len(before)+len(after))
+	for addr := range before {
+		addrs = append(addrs, addr)
+	}
+	for addr := range after {
+		if _, ok := before[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+
+	var added, removed, changed int
+	for _, addr := range addrs {
+		old, inBefore := before[addr]
+		new, inAfter := after[addr]
+		switch {
+		case !inBefore:
+			added++
+			fmt.Fprintf(w, "+ %s\n", addr)
+		case !inAfter:
+			removed++
+			fmt.Fprintf(w, "- %s\n", addr)
+		case !labelsEqual(old, new):
+			changed++
+			fmt.Fprintf(w, "~ %s\n", addr)
+			diffLabels(w, old, new)
+		}
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d changed\n", added, removed, changed)
+}
+
+func labelsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/scaleway/prometheus-scw-sd/scwtest"
+)
+
+func TestRunDiff(t *testing.T) {
+	s := scwtest.NewServer(testToken)
+	defer s.Close()
+	defer s.Use()()
+	setupFleet(s)
+	s.AddHub("fr-par", scwtest.Hub{ID: "hub-1", Name: "sensors", Status: "ready", Enabled: true, Endpoint: "iot.fr-par.scw.cloud", Organization: "org-1"})
+
+	dir, err := ioutil.TempDir("", "prometheus-scw-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	static := filepath.Join(dir, "static.yml")
+	if err := ioutil.WriteFile(static, []byte(`- targets: [ "legacy.example.com:9100" ]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"--target.group-by-tag=team", "--target.static-file=" + static, "--iot.regions=fr-par"}
+
+	groups, err := runOnceWith(s, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "scw.json")
+	b, err := json.Marshal(groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want []string
+	}{
+		{
+			// The file is compared with the targets the once command would write.
+			args: args,
+			want: []string{"0 added, 0 removed, 0 changed"},
+		},
+		{
+			args: []string{"--target.group-by-tag=team", "--target.static-file=" + static},
+			want: []string{"- iot.fr-par.scw.cloud:8883", "0 added, 1 removed, 0 changed"},
+		},
+		{
+			args: append([]string{"--filter.exclude-ids=db-1"}, args...),
+			want: []string{"- 10.0.0.3:80", "0 added, 1 removed, 0 changed"},
+		},
+	} {
+		resetFlags()
+		if _, err := a.Parse(append([]string{"diff", file}, tc.args...)); err != nil {
+			t.Fatal(err)
+		}
+		logger := &scwLogger{log.NewNopLogger()}
+		client, err := newAPIClient(testToken, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := runDiff(client, logger, *diffFile, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Errorf("%v: expected %q, got %q", tc.args, tc.want, got)
+		}
+	}
+}
+
+func TestRunDiffSplit(t *testing.T) {
+	s := scwtest.NewServer(testToken)
+	defer s.Close()
+	defer s.Use()()
+	setupFleet(s)
+
+	dir, err := ioutil.TempDir("", "prometheus-scw-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	groups, err := runOnceWith(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "scw.json")
+	if err := newFileOutput(file, 0, 1, 0).Write(marshalGroups(groups)); err != nil {
+		t.Fatal(err)
+	}
+
+	resetFlags()
+	if _, err := a.Parse([]string{"diff", "--output.max-targets=1", file}); err != nil {
+		t.Fatal(err)
+	}
+	logger := &scwLogger{log.NewNopLogger()}
+	client, err := newAPIClient(testToken, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := runDiff(client, logger, *diffFile, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != "0 added, 0 removed, 0 changed" {
+		t.Errorf("expected the numbered files to hold the targets, got %q", got)
+	}
+}
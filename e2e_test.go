@@ -0,0 +1,399 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/scaleway/go-scaleway/types"
+	"github.com/scaleway/prometheus-scw-sd/scwtest"
+)
+
+const testToken = "00000000-0000-0000-0000-000000000000"
+
+func TestMain(m *testing.M) {
+	registerSharedFlags()
+	os.Exit(m.Run())
+}
+
+// testServer returns a server of the test fleet.
+func testServer(id, zone, state, privateIP string, tags ...string) types.ScalewayServer {
+	srv := types.ScalewayServer{
+		Identifier:       id,
+		Name:             id,
+		Hostname:         id,
+		State:            state,
+		PrivateIP:        privateIP,
+		Organization:     "org-1",
+		CommercialType:   "START1-S",
+		Tags:             tags,
+		ModificationDate: "2018-05-01T10:00:00Z",
+	}
+	srv.Location.ZoneID = zone
+	srv.Location.Hypervisor = "hv-" + id
+	srv.SecurityGroup.Identifier = "sg-default"
+	return srv
+}
+
+// setupFleet adds the servers shared by the test cases to the fake API.
+func setupFleet(s *scwtest.Server) {
+	s.AddOrganization("org-1", "acme")
+	web := testServer("web-1", "par1", "running", "10.0.0.1", "web", "team=front")
+	web.PublicAddress.IP = "51.15.0.1"
+	s.SetServers(
+		web,
+		testServer("web-2", "ams1", "running", "10.0.0.2", "web", "team=front"),
+		testServer("db-1", "par1", "running", "10.0.0.3", "db", "team=back"),
+		testServer("old-1", "par1", "stopped", "10.0.0.4", "web"),
+		testServer("boot-1", "ams1", "stopping", "10.0.0.5", "web"),
+	)
+	s.SetSecurityGroup("sg-default", "accept")
+}
+
+// resetFlags clears the repeatable flags, which accumulate over the parses.
+func resetFlags() {
+	organizations = nil
+	filterTags = nil
+	excludeTags = nil
+	excludeIDs = nil
+	probePorts = nil
+	iotRegions = nil
+}
+
+// runOnceWith runs the once command with the arguments against the fake API
+// and returns the written targets.
+func runOnceWith(s *scwtest.Server, args ...string) ([]customSD, error) {
+	dir, err := ioutil.TempDir("", "prometheus-scw-sd")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte(testToken+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	out := filepath.Join(dir, "scw.json")
+
+	resetFlags()
+	args = append([]string{"once", "--scw.token-file=" + tokenFile, "--output.file=" + out}, args...)
+	if _, err := a.Parse(args); err != nil {
+		return nil, err
+	}
+	iotAPI = s.IoTURL()
+	vpcAPI, ipamAPI = s.VPCURL(), s.IPAMURL()
+
+	logger := &scwLogger{log.NewNopLogger()}
+	token, err := readToken()
+	if err != nil {
+		return nil, err
+	}
+	client, err := newAPIClient(token, logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := runOnce(client, logger); err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		return nil, err
+	}
+	var groups []customSD
+	if err := json.Unmarshal(b, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// targets returns the sorted targets of the groups.
+func targets(groups []customSD) []string {
+	var res []string
+	for _, g := range groups {
+		res = append(res, g.Targets...)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// groupOf returns the group of the target.
+func groupOf(groups []customSD, target string) *customSD {
+	for i, g := range groups {
+		for _, t := range g.Targets {
+			if t == target {
+				return &groups[i]
+			}
+		}
+	}
+	return nil
+}
+
+func TestEndToEnd(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(*scwtest.Server)
+		args  []string
+
+		err     bool
+		targets []string
+		// labels are the expected labels per target (only the given labels are checked).
+		labels map[string]map[string]string
+		// missing are the labels which must not be attached to the targets.
+		missing []string
+	}{
+		{
+			name:    "running servers",
+			targets: []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"},
+			labels: map[string]map[string]string{
+				"10.0.0.1:80": {
+					nameLabel:           "web-1",
+					zoneLabel:           "par1",
+					orgNameLabel:        "acme",
+					publicIPLabel:       "51.15.0.1",
+					tagsLabel:           ",web,team=front,",
+					hypervisorLabel:     "hv-web-1",
+					commercialTypeLabel: "START1-S",
+				},
+				"10.0.0.2:80": {zoneLabel: "ams1"},
+			},
+		},
+		{
+			name:    "tags filter",
+			args:    []string{"--filter.tags=team=front"},
+			targets: []string{"10.0.0.1:80", "10.0.0.2:80"},
+		},
+		{
+			name:    "excluded tags and identifiers",
+			args:    []string{"--filter.exclude-tags=db", "--filter.exclude-ids=web-2"},
+			targets: []string{"10.0.0.1:80"},
+		},
+		{
+			name:    "unknown organization",
+			args:    []string{"--scw.organizations=org-2"},
+			targets: nil,
+		},
+		{
+			name:    "public address and port",
+			args:    []string{"--target.address=public", "--target.port=9100"},
+			targets: []string{"51.15.0.1:9100"},
+		},
+		{
+			name:    "location labels disabled",
+			args:    []string{"--no-target.location-labels"},
+			targets: []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"},
+			missing: []string{hypervisorLabel, platformLabel},
+		},
+		{
+			name:    "grouping by tag",
+			args:    []string{"--target.group-by-tag=team"},
+			targets: []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"},
+			labels: map[string]map[string]string{
+				"10.0.0.1:80": {tagLabel("team"): "front"},
+				"10.0.0.3:80": {tagLabel("team"): "back", nameLabel: "db-1"},
+			},
+		},
+		{
+			name: "scrape hints",
+			setup: func(s *scwtest.Server) {
+				s.SetUserdata("web-1", "prometheus", `{"port":9100,"path":"/metrics/node","labels":{"role":"frontend"}}`)
+				s.SetUserdata("web-2", "prometheus", `{"port":9100,"labels":{"role":"frontend"}}`)
+			},
+			args:    []string{"--scw.userdata-key=prometheus"},
+			targets: []string{"10.0.0.1:9100", "10.0.0.2:9100", "10.0.0.3:80"},
+			labels: map[string]map[string]string{
+				"10.0.0.1:9100": {"__metrics_path__": "/metrics/node", "role": "frontend"},
+				"10.0.0.2:9100": {"role": "frontend"},
+			},
+		},
+		{
+			name:    "transitional states",
+			args:    []string{"--target.include-transitional"},
+			targets: []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.5:80"},
+			labels: map[string]map[string]string{
+				"10.0.0.5:80": {stateLabel: "stopping"},
+			},
+		},
+		{
+			name: "security groups",
+			setup: func(s *scwtest.Server) {
+				s.SetSecurityGroup("sg-default", "accept", types.ScalewaySecurityGroupRule{
+					Direction:    "inbound",
+					Protocol:     "TCP",
+					IPRange:      "0.0.0.0/0",
+					DestPortFrom: 80,
+					Action:       "drop",
+					Position:     1,
+				})
+			},
+			args:    []string{"--target.check-security-groups"},
+			targets: nil,
+		},
+		{
+			name: "pagination",
+			setup: func(s *scwtest.Server) {
+				for i := 0; i < scwtest.PerPage*2+5; i++ {
+					s.AddServer(testServer(fmt.Sprintf("bulk-%03d", i), "par1", "running", fmt.Sprintf("10.1.%d.%d", i/250, i%250), "bulk"))
+				}
+			},
+			args: []string{"--filter.tags=bulk"},
+			targets: func() []string {
+				var res []string
+				for i := 0; i < scwtest.PerPage*2+5; i++ {
+					res = append(res, fmt.Sprintf("10.1.%d.%d:80", i/250, i%250))
+				}
+				sort.Strings(res)
+				return res
+			}(),
+		},
+		{
+			name: "IoT hubs",
+			setup: func(s *scwtest.Server) {
+				s.AddHub("fr-par", scwtest.Hub{ID: "hub-1", Name: "sensors", Status: "ready", Enabled: true, Endpoint: "iot.fr-par.scw.cloud", Organization: "org-1"})
+			},
+			args:    []string{"--iot.regions=fr-par", "--filter.tags=db"},
+			targets: []string{"10.0.0.3:80", "iot.fr-par.scw.cloud:8883"},
+			labels: map[string]map[string]string{
+				"iot.fr-par.scw.cloud:8883": {iotNameLabel: "sensors", iotRegionLabel: "fr-par"},
+			},
+		},
+		{
+			name: "maintenance labels",
+			setup: func(s *scwtest.Server) {
+				s.AddMaintenance("web-1", scwtest.Maintenance{Reason: "hypervisor upgrade", StartDate: "2018-05-03T02:00:00Z"})
+				s.AddMaintenance("web-1", scwtest.Maintenance{Reason: "disk replacement", StartDate: "2018-05-02T02:00:00Z"})
+			},
+			args:    []string{"--target.maintenance-labels"},
+			targets: []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"},
+			labels: map[string]map[string]string{
+				"10.0.0.1:80": {maintenanceStartLabel: "2018-05-02T02:00:00Z", maintenanceReasonLabel: "disk replacement"},
+				"10.0.0.3:80": {maintenanceStartLabel: "", maintenanceReasonLabel: ""},
+			},
+		},
+		{
+			name: "private network",
+			setup: func(s *scwtest.Server) {
+				s.AddPrivateNetwork("fr-par-1", scwtest.PrivateNetwork{ID: "pn-1", Name: "backend"})
+				s.AddPrivateNetwork("fr-par-1", scwtest.PrivateNetwork{ID: "pn-2", Name: "frontend"})
+				s.AttachPrivateNetwork("web-1", "pn-1", "fd00::1/64", "172.16.0.1/22")
+				s.AttachPrivateNetwork("db-1", "pn-2", "172.16.4.3/22")
+			},
+			// web-2 is in a zone without the Private Network.
+			args:    []string{"--private-network=backend"},
+			targets: []string{"172.16.0.1:80"},
+		},
+		{
+			name: "zone error",
+			setup: func(s *scwtest.Server) {
+				s.FailNext(scwtest.Servers, http.StatusInternalServerError, 1)
+			},
+			targets: []string{"10.0.0.2:80"},
+		},
+		{
+			name: "API error",
+			setup: func(s *scwtest.Server) {
+				s.FailNext(scwtest.Servers, http.StatusInternalServerError, 2)
+			},
+			err: true,
+		},
+		{
+			name: "rate limited",
+			setup: func(s *scwtest.Server) {
+				s.FailNext(scwtest.Servers, http.StatusTooManyRequests, 2)
+			},
+			err: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := scwtest.NewServer(testToken)
+			defer s.Close()
+			defer s.Use()()
+			setupFleet(s)
+			if tc.setup != nil {
+				tc.setup(s)
+			}
+
+			groups, err := runOnceWith(s, tc.args...)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := targets(groups); !reflect.DeepEqual(got, tc.targets) {
+				t.Fatalf("expected targets %v, got %v", tc.targets, got)
+			}
+			for target, labels := range tc.labels {
+				g := groupOf(groups, target)
+				for name, want := range labels {
+					if got := g.Labels[name]; got != want {
+						t.Errorf("%s: expected %s=%q, got %q", target, name, want, got)
+					}
+				}
+			}
+			for _, g := range groups {
+				for _, name := range tc.missing {
+					if _, ok := g.Labels[name]; ok {
+						t.Errorf("%s: unexpected label %s", strings.Join(g.Targets, ","), name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTargetInfo(t *testing.T) {
+	s := scwtest.NewServer(testToken)
+	defer s.Close()
+	defer s.Use()()
+	setupFleet(s)
+
+	if _, err := runOnceWith(s); err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var servers []string
+	for _, mf := range mfs {
+		if mf.GetName() != "scw_sd_target_info" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "server_id" {
+					servers = append(servers, l.GetValue())
+				}
+			}
+		}
+	}
+	sort.Strings(servers)
+	if want := []string{"db-1", "web-1", "web-2"}; !reflect.DeepEqual(servers, want) {
+		t.Fatalf("expected scw_sd_target_info for %v, got %v", want, servers)
+	}
+}
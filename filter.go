@@ -0,0 +1,125 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/scaleway/go-scaleway/types"
+)
+
+// serverFilter selects the servers which are turned into targets.
+type serverFilter struct {
+	// orgs restricts the discovery to some organizations (all if empty).
+	orgs map[string]struct{}
+	// tags are the tags that the servers must have (any of them if tagsAny is true).
+	tags    []string
+	tagsAny bool
+	// excludeTags are the tags that the servers must not have (all of them if excludeTagsAll is true).
+	excludeTags    []string
+	excludeTagsAll bool
+	// excludeIDs are the identifiers of the servers which are never discovered.
+	excludeIDs map[string]struct{}
+}
+
+// newServerFilter returns a filter. The identifiers listed in excludeIDsFile are
+// added to excludeIDs.
+func newServerFilter(orgs []string, tags []string, tagsMatch string, excludeTags []string, excludeTagsMatch string, excludeIDs []string, excludeIDsFile string) (*serverFilter, error) {
+	f := &serverFilter{
+		orgs:           make(map[string]struct{}, len(orgs)),
+		tags:           tags,
+		tagsAny:        tagsMatch == "any",
+		excludeTags:    excludeTags,
+		excludeTagsAll: excludeTagsMatch == "all",
+		excludeIDs:     make(map[string]struct{}, len(excludeIDs)),
+	}
+	for _, o := range orgs {
+		f.orgs[o] = struct{}{}
+	}
+	for _, id := range excludeIDs {
+		f.excludeIDs[id] = struct{}{}
+	}
+	if excludeIDsFile != "" {
+		ids, err := readIDsFile(excludeIDsFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			f.excludeIDs[id] = struct{}{}
+		}
+	}
+	return f, nil
+}
+
+// readIDsFile returns the identifiers listed in a file, one per line. Empty
+// lines and lines starting with '#' are ignored.
+func readIDsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	return ids, scanner.Err()
+}
+
+// matchTags returns true if the server has all the tags or any of them when
+// any is true.
+func matchTags(srv *types.ScalewayServer, tags []string, any bool) bool {
+	have := make(map[string]struct{}, len(srv.Tags))
+	for _, t := range srv.Tags {
+		have[t] = struct{}{}
+	}
+	for _, t := range tags {
+		_, ok := have[t]
+		if ok && any {
+			return true
+		}
+		if !ok && !any {
+			return false
+		}
+	}
+	return !any
+}
+
+// reject returns the name of the rule rejecting the server or an empty string
+// if the server is selected.
+func (f *serverFilter) reject(srv *types.ScalewayServer) string {
+	if _, ok := f.excludeIDs[srv.Identifier]; ok {
+		return "exclude-ids"
+	}
+	if len(f.orgs) > 0 {
+		if _, ok := f.orgs[srv.Organization]; !ok {
+			return "organization"
+		}
+	}
+	if len(f.tags) > 0 && !matchTags(srv, f.tags, f.tagsAny) {
+		return "tags"
+	}
+	if len(f.excludeTags) > 0 && matchTags(srv, f.excludeTags, !f.excludeTagsAll) {
+		return "exclude-tags"
+	}
+	return ""
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// tagLabel returns the name of the label holding the value of a tag key.
+func tagLabel(key string) string {
+	return scwPrefix + "tag_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// tagValue returns the value of the "key=value" or "key:value" tag of the
+// group or false if there is none.
+func tagValue(g customSD, key string) (string, bool) {
+	// The groups of a previous grouping already have the label.
+	if v, ok := g.Labels[tagLabel(key)]; ok {
+		return v, true
+	}
+	for _, t := range strings.Split(strings.Trim(g.Labels[tagsLabel], ","), ",") {
+		for _, sep := range []string{"=", ":"} {
+			if strings.HasPrefix(t, key+sep) {
+				return t[len(key)+1:], true
+			}
+		}
+	}
+	return "", false
+}
+
+// groupTargetsByTag merges the groups having the same value for the tag key into a
+// single group labeled with the value. The merged group keeps the labels
+// shared by all its members only. The groups without the tag are left as is.
+func groupTargetsByTag(arr []customSD, key string) []customSD {
+	var (
+		res    []customSD
+		byVal  = make(map[string]*customSD)
+		values []string
+	)
+	for _, g := range arr {
+		v, ok := tagValue(g, key)
+		if !ok {
+			res = append(res, g)
+			continue
+		}
+		m, ok := byVal[v]
+		if !ok {
+			labels := make(map[string]string, len(g.Labels)+1)
+			for name, value := range g.Labels {
+				labels[name] = value
+			}
+			labels[tagLabel(key)] = v
+			byVal[v] = &customSD{Targets: append([]string(nil), g.Targets...), Labels: labels}
+			values = append(values, v)
+			continue
+		}
+		m.Targets = append(m.Targets, g.Targets...)
+		for name, value := range m.Labels {
+			if name == tagLabel(key) {
+				continue
+			}
+			if g.Labels[name] != value {
+				delete(m.Labels, name)
+			}
+		}
+	}
+
+	sort.Strings(values)
+	for _, v := range values {
+		m := byVal[v]
+		if len(m.Targets) > 1 {
+			// The address of a single target doesn't make sense for the group.
+			delete(m.Labels, model.AddressLabel)
+		}
+		sort.Strings(m.Targets)
+		res = append(res, *m)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].sortKey() < res[j].sortKey()
+	})
+	return res
+}
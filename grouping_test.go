@@ -0,0 +1,77 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagLabel(t *testing.T) {
+	for key, want := range map[string]string{
+		"team":                scwPrefix + "tag_team",
+		"app.kubernetes/name": scwPrefix + "tag_app_kubernetes_name",
+		"env-2":               scwPrefix + "tag_env_2",
+	} {
+		if got := tagLabel(key); got != want {
+			t.Errorf("%s: expected %q, got %q", key, want, got)
+		}
+	}
+}
+
+func TestGroupTargetsByTag(t *testing.T) {
+	groups := []customSD{
+		{
+			Targets: []string{"10.0.0.2:80"},
+			Labels:  map[string]string{"__address__": "10.0.0.2:80", nameLabel: "web-2", zoneLabel: "par1", tagsLabel: ",team:front,"},
+		},
+		{
+			Targets: []string{"10.0.0.4:80"},
+			Labels:  map[string]string{"__address__": "10.0.0.4:80", nameLabel: "web-4", zoneLabel: "ams1", tagsLabel: ",web,"},
+		},
+		{
+			Targets: []string{"10.0.0.1:80"},
+			Labels:  map[string]string{"__address__": "10.0.0.1:80", nameLabel: "web-1", zoneLabel: "par1", tagsLabel: ",web,team=front,"},
+		},
+		{
+			Targets: []string{"10.0.0.3:80"},
+			Labels:  map[string]string{"__address__": "10.0.0.3:80", nameLabel: "db-1", zoneLabel: "ams1", tagsLabel: ",db,team=back,"},
+		},
+	}
+	want := []customSD{
+		{
+			// The merged group keeps the shared labels only.
+			Targets: []string{"10.0.0.1:80", "10.0.0.2:80"},
+			Labels:  map[string]string{zoneLabel: "par1", tagLabel("team"): "front"},
+		},
+		{
+			Targets: []string{"10.0.0.3:80"},
+			Labels:  map[string]string{"__address__": "10.0.0.3:80", nameLabel: "db-1", zoneLabel: "ams1", tagsLabel: ",db,team=back,", tagLabel("team"): "back"},
+		},
+		{
+			// The group without the tag is left as is.
+			Targets: []string{"10.0.0.4:80"},
+			Labels:  map[string]string{"__address__": "10.0.0.4:80", nameLabel: "web-4", zoneLabel: "ams1", tagsLabel: ",web,"},
+		},
+	}
+
+	got := groupTargetsByTag(groups, "team")
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	// Grouping again uses the label of the previous grouping.
+	if again := groupTargetsByTag(got, "team"); !reflect.DeepEqual(again, want) {
+		t.Errorf("expected the grouping to be stable, got %v", again)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+const (
+	// hookTimeout is the maximum duration of a hook command.
+	hookTimeout = 30 * time.Second
+	// hookQueueSize is the number of pending hook commands before new events are dropped.
+	hookQueueSize = 1000
+)
+
+var hookFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "prometheus_scaleway_sd_hook_failures_total",
+		Help: "Total number of failed or dropped hook commands.",
+	},
+	[]string{"event"},
+)
+
+func init() {
+	reg.MustRegister(hookFailures)
+}
+
+type hookEvent struct {
+	name  string
+	args  []string
+	group *targetgroup.Group
+}
+
+// hookRunner executes commands when targets are added or removed. The
+// commands are run one at a time in the order of the events.
+type hookRunner struct {
+	onAdd    []string
+	onRemove []string
+	events   chan hookEvent
+	logger   log.Logger
+}
+
+// newHookRunner returns a hook runner or nil if no hook is configured.
+func newHookRunner(onAdd, onRemove string, logger log.Logger) *hookRunner {
+	if onAdd == "" && onRemove == "" {
+		return nil
+	}
+	return &hookRunner{
+		onAdd:    strings.Fields(onAdd),
+		onRemove: strings.Fields(onRemove),
+		events:   make(chan hookEvent, hookQueueSize),
+		logger:   log.With(logger, "component", "hooks"),
+	}
+}
+
+// hookEnv returns the environment of a hook command: the labels of the target
+// are passed as SCW_SD_<LABEL> variables (eg SCW_SD_ADDRESS or SCW_SD_META_SCALEWAY_NAME).
+func hookEnv(event string, tg *targetgroup.Group) []string {
+	env := append(os.Environ(), "SCW_SD_EVENT="+event, "SCW_SD_SOURCE="+tg.Source)
+	for name, value := range tg.Labels {
+		env = append(env, "SCW_SD_"+strings.ToUpper(strings.Trim(string(name), "_"))+"="+string(value))
+	}
+	return env
+}
+
+func (h *hookRunner) enqueue(name string, args []string, tg *targetgroup.Group) {
+	if len(args) == 0 {
+		return
+	}
+	select {
+	case h.events <- hookEvent{name: name, args: args, group: tg}:
+	default:
+		hookFailures.WithLabelValues(name).Inc()
+		level.Warn(h.logger).Log("msg", "too many pending hooks, dropping event", "event", name, "source", tg.Source)
+	}
+}
+
+// added runs the on-add hook for the target group.
+func (h *hookRunner) added(tg *targetgroup.Group) {
+	if h != nil {
+		h.enqueue("add", h.onAdd, tg)
+	}
+}
+
+// removed runs the on-remove hook for the target group.
+func (h *hookRunner) removed(tg *targetgroup.Group) {
+	if h != nil {
+		h.enqueue("remove", h.onRemove, tg)
+	}
+}
+
+// Run executes the hook commands until the context is done.
+func (h *hookRunner) Run(ctx context.Context) {
+	for {
+		select {
+		case ev := <-h.events:
+			h.exec(ctx, ev)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *hookRunner) exec(ctx context.Context, ev hookEvent) {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, ev.args[0], ev.args[1:]...)
+	cmd.Env = hookEnv(ev.name, ev.group)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		hookFailures.WithLabelValues(ev.name).Inc()
+		level.Error(h.logger).Log("msg", "hook failed", "event", ev.name, "source", ev.group.Source, "err", err, "output", string(out))
+		return
+	}
+	level.Debug(h.logger).Log("msg", "hook executed", "event", ev.name, "source", ev.group.Source)
+}
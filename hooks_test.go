@@ -0,0 +1,87 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+)
+
+func TestHookEnv(t *testing.T) {
+	tg := testGroup("scaleway/web-1", "10.0.0.1:80", model.LabelSet{
+		model.AddressLabel:          "10.0.0.1:80",
+		model.LabelName(nameLabel):  "web-1",
+		model.LabelName(zoneLabel):  "par1",
+		model.LabelName("__param_"): "trailing",
+	})
+	env := make(map[string]string)
+	for _, kv := range hookEnv("add", tg) {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	for name, want := range map[string]string{
+		"SCW_SD_EVENT":                 "add",
+		"SCW_SD_SOURCE":                "scaleway/web-1",
+		"SCW_SD_ADDRESS":               "10.0.0.1:80",
+		"SCW_SD_META_SCALEWAY_NAME":    "web-1",
+		"SCW_SD_META_SCALEWAY_ZONE_ID": "par1",
+		"SCW_SD_PARAM":                 "trailing",
+	} {
+		if got, ok := env[name]; !ok || got != want {
+			t.Errorf("expected %s=%q, got %q", name, want, got)
+		}
+	}
+	if _, ok := env["SCW_SD_ADDRESS__"]; ok {
+		t.Error("unexpected SCW_SD_ADDRESS__ variable")
+	}
+}
+
+func TestHookRunnerExec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prometheus-scw-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	if err := ioutil.WriteFile(script, []byte(`echo "$SCW_SD_EVENT,$SCW_SD_ADDRESS" >> "$1"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newHookRunner("sh "+script+" "+out, "", log.NewNopLogger())
+	h.added(testGroup("scaleway/web-1", "10.0.0.1:80", model.LabelSet{model.AddressLabel: "10.0.0.1:80"}))
+	// The on-remove hook isn't configured.
+	h.removed(testGroup("scaleway/web-2", "10.0.0.2:80", model.LabelSet{model.AddressLabel: "10.0.0.2:80"}))
+	if len(h.events) != 1 {
+		t.Fatalf("expected 1 pending event, got %d", len(h.events))
+	}
+	h.exec(context.Background(), <-h.events)
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(b)); got != "add,10.0.0.1:80" {
+		t.Errorf("expected the hook to receive the target, got %q", got)
+	}
+}
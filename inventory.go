@@ -0,0 +1,136 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// inventoryKind identifies the inventory documents.
+const inventoryKind = "ScalewayServerInventory"
+
+// inventory lists the discovered servers for the tools which need the
+// servers rather than the targets (eg SLO tooling and CMDBs).
+type inventory struct {
+	Kind        string            `json:"kind"`
+	GeneratedAt string            `json:"generated_at"`
+	Servers     []inventoryServer `json:"servers"`
+}
+
+// inventoryServer is a discovered server along with its targets.
+type inventoryServer struct {
+	ID               string   `json:"id"`
+	Name             string   `json:"name"`
+	Hostname         string   `json:"hostname"`
+	Organization     string   `json:"organization"`
+	OrganizationName string   `json:"organization_name"`
+	Zone             string   `json:"zone"`
+	State            string   `json:"state"`
+	CommercialType   string   `json:"commercial_type"`
+	PrivateIP        string   `json:"private_ip"`
+	PublicIP         string   `json:"public_ip"`
+	Tags             []string `json:"tags"`
+	Targets          []string `json:"targets"`
+	// Labels holds all the meta labels of the server, without their prefix.
+	Labels map[string]string `json:"labels"`
+}
+
+// inventoryOutput writes the discovered servers to a file as an inventory document.
+type inventoryOutput struct {
+	path string
+}
+
+func newInventoryOutput(path string) *inventoryOutput {
+	return &inventoryOutput{path: path}
+}
+
+// Name implements the output interface.
+func (i *inventoryOutput) Name() string {
+	return "inventory"
+}
+
+// Write implements the output interface. The inventory is only written from
+// the discovered groups, by WriteGroups.
+func (i *inventoryOutput) Write(b []byte) error {
+	return errors.New("the inventory is written from the discovered groups")
+}
+
+// WriteGroups implements the groupsOutput interface.
+func (i *inventoryOutput) WriteGroups(groups []customSD) error {
+	inv, err := json.MarshalIndent(newInventory(groups, time.Now()), "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFile(i.path, inv)
+}
+
+// newInventory returns the inventory of the servers of the discovered groups.
+// The groups which don't belong to a server (eg the IoT Hubs) aren't listed.
+func newInventory(groups []customSD, now time.Time) *inventory {
+	servers := make(map[string]*inventoryServer)
+	for _, g := range groups {
+		id := g.Labels[identifierLabel]
+		if id == "" {
+			continue
+		}
+		// The probed ports of a server are in different groups.
+		if s, ok := servers[id]; ok {
+			s.Targets = append(s.Targets, g.Targets...)
+			continue
+		}
+
+		labels := make(map[string]string, len(g.Labels))
+		for name, value := range g.Labels {
+			if !strings.HasPrefix(name, scwPrefix) || name == detectedExporterLabel {
+				continue
+			}
+			labels[strings.TrimPrefix(name, scwPrefix)] = value
+		}
+		tags := []string{}
+		if t := strings.Trim(g.Labels[tagsLabel], ","); t != "" {
+			tags = strings.Split(t, ",")
+		}
+		servers[id] = &inventoryServer{
+			ID:               id,
+			Name:             g.Labels[nameLabel],
+			Hostname:         g.Labels[hostnameLabel],
+			Organization:     g.Labels[orgLabel],
+			OrganizationName: g.Labels[orgNameLabel],
+			Zone:             g.Labels[zoneLabel],
+			State:            g.Labels[stateLabel],
+			CommercialType:   g.Labels[commercialTypeLabel],
+			PrivateIP:        g.Labels[privateIPLabel],
+			PublicIP:         g.Labels[publicIPLabel],
+			Tags:             tags,
+			Targets:          append([]string(nil), g.Targets...),
+			Labels:           labels,
+		}
+	}
+
+	inv := &inventory{
+		Kind:        inventoryKind,
+		GeneratedAt: now.UTC().Format(time.RFC3339),
+		Servers:     make([]inventoryServer, 0, len(servers)),
+	}
+	for _, s := range servers {
+		sort.Strings(s.Targets)
+		inv.Servers = append(inv.Servers, *s)
+	}
+	sort.Slice(inv.Servers, func(i, j int) bool { return inv.Servers[i].ID < inv.Servers[j].ID })
+	return inv
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func TestInventoryOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prometheus-scw-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "inventory.json")
+
+	out := &memoryOutput{name: "file"}
+	a := NewAdapter(context.Background(), []output{out, newInventoryOutput(path)}, "scw", nil, nil, log.NewNopLogger())
+	a.groupByTag = "team"
+	err = a.WriteOnce([]*targetgroup.Group{
+		testGroup("scaleway/web-1", "10.0.0.1:80", model.LabelSet{model.LabelName(identifierLabel): "web-1", model.LabelName(nameLabel): "web-1", model.LabelName(tagsLabel): ",web,team=front,"}),
+		testGroup("scaleway/web-2", "10.0.0.2:80", model.LabelSet{model.LabelName(identifierLabel): "web-2", model.LabelName(nameLabel): "web-2", model.LabelName(tagsLabel): ",web,team=front,"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The servers are listed even though their targets are merged by tag.
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inv inventory
+	if err := json.Unmarshal(b, &inv); err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Servers) != 2 {
+		t.Fatalf("expected 2 servers, got %s", b)
+	}
+	for i, id := range []string{"web-1", "web-2"} {
+		s := inv.Servers[i]
+		if s.ID != id || len(s.Targets) != 1 || len(s.Tags) != 2 {
+			t.Errorf("unexpected server %d: %+v", i, s)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// iotAPI is the base URL of the IoT Hub API.
+var iotAPI = "https://api.scaleway.com/iot/v1"
+
+// iotPageSize is the number of hubs requested per page.
+const iotPageSize = 100
+
+var (
+	iotPrefix = scwPrefix + "iot_hub_"
+	// iotIDLabel is the name for the label containing the hub's identifier.
+	iotIDLabel = iotPrefix + "id"
+	// iotNameLabel is the name for the label containing the hub's name.
+	iotNameLabel = iotPrefix + "name"
+	// iotPlanLabel is the name for the label containing the hub's product plan.
+	iotPlanLabel = iotPrefix + "product_plan"
+	// iotStatusLabel is the name for the label containing the hub's status.
+	iotStatusLabel = iotPrefix + "status"
+	// iotEnabledLabel is the name for the label containing whether the hub is enabled.
+	iotEnabledLabel = iotPrefix + "enabled"
+	// iotRegionLabel is the name for the label containing the hub's region.
+	iotRegionLabel = iotPrefix + "region"
+)
+
+// iotHub is an IoT Hub as returned by the API.
+type iotHub struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Status       string `json:"status"`
+	ProductPlan  string `json:"product_plan"`
+	Enabled      bool   `json:"enabled"`
+	Endpoint     string `json:"endpoint"`
+	Region       string `json:"region"`
+	Organization string `json:"organization_id"`
+}
+
+// iotDiscoverer retrieves the endpoints of the IoT Hubs as probe targets.
+type iotDiscoverer struct {
+	token   string
+	regions []string
+	port    int
+	refresh int
+	orgs    map[string]struct{}
+	// scheduler coordinates the API requests with the other products.
+	scheduler *apiScheduler
+	// reloadCh receives a value when the token must be read again.
+	reloadCh <-chan struct{}
+	// sources are the sources of the groups returned by the last refresh.
+	sources map[string]struct{}
+	logger  log.Logger
+}
+
+// newIoTDiscoverer returns a discoverer of the hubs in the given regions or nil
+// if no region is given.
+func newIoTDiscoverer(token string, regions []string, port, refresh int, orgs map[string]struct{}, logger log.Logger) *iotDiscoverer {
+	if len(regions) == 0 {
+		return nil
+	}
+	return &iotDiscoverer{
+		token:   token,
+		regions: regions,
+		port:    port,
+		refresh: refresh,
+		orgs:    orgs,
+		sources: make(map[string]struct{}),
+		logger:  log.With(logger, "component", "iot"),
+	}
+}
+
+// listHubs returns all the hubs of a region.
+func (d *iotDiscoverer) listHubs(region string) ([]iotHub, error) {
+	var hubs []iotHub
+	for page := 1; ; page++ {
+		q := url.Values{}
+		q.Set("page", strconv.Itoa(page))
+		q.Set("page_size", strconv.Itoa(iotPageSize))
+		var res struct {
+			Hubs       []iotHub `json:"hubs"`
+			TotalCount int      `json:"total_count"`
+		}
+		if err := apiRequest(d.token, fmt.Sprintf("%s/regions/%s/hubs?%s", iotAPI, region, q.Encode()), &res); err != nil {
+			return nil, err
+		}
+		hubs = append(hubs, res.Hubs...)
+		if len(res.Hubs) == 0 || len(hubs) >= res.TotalCount {
+			return hubs, nil
+		}
+	}
+}
+
+func (d *iotDiscoverer) createTarget(hub *iotHub) *targetgroup.Group {
+	addr := model.LabelValue(net.JoinHostPort(hub.Endpoint, strconv.Itoa(d.port)))
+	return &targetgroup.Group{
+		Source: fmt.Sprintf("scaleway-iot/%s", hub.ID),
+		Targets: []model.LabelSet{
+			model.LabelSet{
+				model.AddressLabel: addr,
+			},
+		},
+		Labels: model.LabelSet{
+			model.AddressLabel:               addr,
+			model.LabelName(iotIDLabel):      model.LabelValue(hub.ID),
+			model.LabelName(iotNameLabel):    model.LabelValue(hub.Name),
+			model.LabelName(iotPlanLabel):    model.LabelValue(hub.ProductPlan),
+			model.LabelName(iotStatusLabel):  model.LabelValue(hub.Status),
+			model.LabelName(iotEnabledLabel): model.LabelValue(strconv.FormatBool(hub.Enabled)),
+			model.LabelName(iotRegionLabel):  model.LabelValue(hub.Region),
+			model.LabelName(orgLabel):        model.LabelValue(hub.Organization),
+		},
+	}
+}
+
+// getTargets returns a target group per hub. The groups of the hubs which
+// have been deleted since the last refresh are returned empty.
+func (d *iotDiscoverer) getTargets() ([]*targetgroup.Group, error) {
+	current := make(map[string]struct{})
+	var tgs []*targetgroup.Group
+	for _, region := range d.regions {
+		hubs, err := d.listHubs(region)
+		if err != nil {
+			refreshStatus.failure("iot", region, err)
+			return nil, fmt.Errorf("failed to list the hubs of %s: %v", region, err)
+		}
+		refreshStatus.success("iot", region, len(hubs))
+		level.Debug(d.logger).Log("msg", "get hubs", "region", region, "nb", len(hubs))
+		for i := range hubs {
+			if _, ok := d.orgs[hubs[i].Organization]; len(d.orgs) > 0 && !ok {
+				continue
+			}
+			if hubs[i].Endpoint == "" {
+				// The hub isn't ready yet.
+				continue
+			}
+			tg := d.createTarget(&hubs[i])
+			current[tg.Source] = struct{}{}
+			tgs = append(tgs, tg)
+		}
+	}
+	for k := range d.sources {
+		if _, ok := current[k]; !ok {
+			level.Debug(d.logger).Log("msg", "hub deleted", "source", k)
+			tgs = append(tgs, &targetgroup.Group{Source: k})
+		}
+	}
+	d.sources = current
+	return tgs, nil
+}
+
+// Run implements the Discoverer interface.
+func (d *iotDiscoverer) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
+	for c := time.Tick(time.Duration(d.refresh) * time.Second); ; {
+		var (
+			tgs []*targetgroup.Group
+			err error
+		)
+		start := time.Now()
+		if !d.scheduler.do(ctx, "iot", func() { tgs, err = d.getTargets() }) {
+			return
+		}
+		observeRefresh("scaleway_iot", start, err)
+		if err == nil {
+			ch <- tgs
+		} else {
+			level.Error(d.logger).Log("msg", "failed to discover the hubs", "err", err)
+		}
+
+		select {
+		case <-c:
+			// Pick up the rotated credentials.
+			d.reload()
+			continue
+		case <-d.reloadCh:
+			d.reload()
+			continue
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"k8s.io/client-go/rest"
+)
+
+// namespaceFile holds the namespace of the pod running the service discovery.
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// scrapeConfigOutput applies the targets as the static configs of a
+// Prometheus Operator ScrapeConfig resource in the Kubernetes cluster
+// running the service discovery.
+type scrapeConfigOutput struct {
+	client    *http.Client
+	host      string
+	name      string
+	namespace string
+}
+
+func newScrapeConfigOutput(name, namespace string) (*scrapeConfigOutput, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	rt, err := rest.TransportFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		b, err := ioutil.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the namespace of the pod: %v", err)
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+	return &scrapeConfigOutput{
+		client:    &http.Client{Transport: rt},
+		host:      cfg.Host,
+		name:      name,
+		namespace: namespace,
+	}, nil
+}
+
+// Name implements the output interface.
+func (s *scrapeConfigOutput) Name() string {
+	return "scrapeconfig"
+}
+
+// Write implements the output interface.
+func (s *scrapeConfigOutput) Write(b []byte) error {
+	// The target groups have the same format as the static configs.
+	body, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1alpha1",
+		"kind":       "ScrapeConfig",
+		"metadata": map[string]interface{}{
+			"name":      s.name,
+			"namespace": s.namespace,
+			"labels": map[string]string{
+				"app.kubernetes.io/managed-by": "prometheus-scw-sd",
+			},
+		},
+		"spec": map[string]interface{}{
+			"staticConfigs": json.RawMessage(b),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Server-side apply creates the resource or updates the fields owned by the service discovery.
+	url := fmt.Sprintf("%s/apis/monitoring.coreos.com/v1alpha1/namespaces/%s/scrapeconfigs/%s?fieldManager=prometheus-scw-sd&force=true", s.host, s.namespace, s.name)
+	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/apply-patch+yaml")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to apply the ScrapeConfig %s/%s: %s: %s", s.namespace, s.name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
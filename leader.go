@@ -0,0 +1,188 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	consul "github.com/hashicorp/consul/api"
+)
+
+// leaderRetryInterval is the delay between two attempts to acquire the leadership.
+const leaderRetryInterval = 5 * time.Second
+
+// acquireFunc blocks until the leadership is acquired or the context is done.
+// The returned channel is closed when the leadership is lost.
+type acquireFunc func(ctx context.Context) (<-chan struct{}, error)
+
+// leaderElector campaigns for the leadership so that only one replica writes
+// the output while the others keep discovering targets.
+type leaderElector struct {
+	acquire acquireFunc
+	elected chan struct{}
+	logger  log.Logger
+
+	mtx    sync.RWMutex
+	leader bool
+}
+
+// newLeaderElector returns the leader elector for the given backend or nil if
+// the leader election is disabled.
+func newLeaderElector(backend, lock, consulAddr string, logger log.Logger) (*leaderElector, error) {
+	var (
+		acquire acquireFunc
+		err     error
+	)
+	switch backend {
+	case "", "none":
+		return nil, nil
+	case "file":
+		if lock == "" {
+			return nil, fmt.Errorf("missing lock file for the file leader election")
+		}
+		acquire, err = fileLockAcquirer(lock)
+		if err != nil {
+			return nil, err
+		}
+	case "consul":
+		if lock == "" {
+			return nil, fmt.Errorf("missing key for the consul leader election")
+		}
+		acquire, err = consulLockAcquirer(consulAddr, lock, logger)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown leader election backend %q", backend)
+	}
+	return &leaderElector{
+		acquire: acquire,
+		elected: make(chan struct{}, 1),
+		logger:  log.With(logger, "component", "leader-election", "backend", backend),
+	}, nil
+}
+
+// IsLeader returns true if the current process holds the leadership.
+// A nil elector is always the leader.
+func (e *leaderElector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.leader
+}
+
+// Elected returns a channel receiving a value every time the leadership is acquired.
+func (e *leaderElector) Elected() <-chan struct{} {
+	if e == nil {
+		return nil
+	}
+	return e.elected
+}
+
+func (e *leaderElector) setLeader(leader bool) {
+	e.mtx.Lock()
+	e.leader = leader
+	e.mtx.Unlock()
+}
+
+// Run campaigns for the leadership until the context is done.
+func (e *leaderElector) Run(ctx context.Context) {
+	for {
+		lost, err := e.acquire(ctx)
+		if err != nil {
+			level.Error(e.logger).Log("msg", "failed to acquire the leadership", "err", err)
+			select {
+			case <-time.After(leaderRetryInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		level.Info(e.logger).Log("msg", "leadership acquired")
+		e.setLeader(true)
+		select {
+		case e.elected <- struct{}{}:
+		default:
+		}
+
+		select {
+		case <-lost:
+			level.Warn(e.logger).Log("msg", "leadership lost")
+			e.setLeader(false)
+		case <-ctx.Done():
+			e.setLeader(false)
+			return
+		}
+	}
+}
+
+// consulLockAcquirer acquires the leadership by holding a Consul lock.
+func consulLockAcquirer(addr, key string, logger log.Logger) (acquireFunc, error) {
+	cfg := consul.DefaultConfig()
+	cfg.Address = addr
+	client, err := consul.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := client.LockOpts(&consul.LockOptions{
+		Key:         key,
+		SessionName: "prometheus-scw-sd",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) (<-chan struct{}, error) {
+		stopCh := make(chan struct{})
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				close(stopCh)
+			case <-done:
+			}
+		}()
+		lost, err := lock.Lock(stopCh)
+		if err != nil || lost == nil {
+			return lost, err
+		}
+
+		released := make(chan struct{})
+		go func() {
+			select {
+			case <-lost:
+			case <-ctx.Done():
+			}
+			// The lock must be released even when it has been lost,
+			// otherwise the next Lock call returns ErrLockHeld.
+			if err := lock.Unlock(); err != nil && err != consul.ErrLockNotHeld {
+				level.Debug(logger).Log("msg", "failed to release the Consul lock", "err", err)
+			}
+			close(released)
+		}()
+		return released, nil
+	}, nil
+}
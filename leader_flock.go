@@ -0,0 +1,52 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileLockAcquirer acquires the leadership by holding an exclusive lock on a
+// file. The lock is released by the operating system when the process exits.
+func fileLockAcquirer(path string) (acquireFunc, error) {
+	return func(ctx context.Context) (<-chan struct{}, error) {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+			if err == nil {
+				// The file is intentionally kept open to hold the lock.
+				return nil, nil
+			}
+			if err != syscall.EWOULDBLOCK {
+				f.Close()
+				return nil, err
+			}
+			select {
+			case <-time.After(leaderRetryInterval):
+			case <-ctx.Done():
+				f.Close()
+				return nil, nil
+			}
+		}
+	}, nil
+}
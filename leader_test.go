@@ -0,0 +1,177 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	consul "github.com/hashicorp/consul/api"
+)
+
+// fakeConsul implements the session and KV endpoints used by the Consul locks.
+type fakeConsul struct {
+	mtx      sync.Mutex
+	index    uint64
+	session  string
+	sessions int
+	// changed is closed at every change of the lock.
+	changed chan struct{}
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{index: 1, changed: make(chan struct{})}
+}
+
+// setSession changes the holder of the lock, mtx must be held.
+func (c *fakeConsul) setSession(s string) {
+	c.session = s
+	c.index++
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// expire invalidates the session holding the lock.
+func (c *fakeConsul) expire() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.setSession("")
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/session/create"):
+		c.sessions++
+		json.NewEncoder(w).Encode(map[string]string{"ID": fmt.Sprintf("session-%d", c.sessions)})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/"):
+		w.Write([]byte("true"))
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		q := r.URL.Query()
+		switch {
+		case q.Get("acquire") != "":
+			ok := c.session == "" || c.session == q.Get("acquire")
+			if ok {
+				c.setSession(q.Get("acquire"))
+			}
+			json.NewEncoder(w).Encode(ok)
+		case q.Get("release") != "":
+			ok := c.session == q.Get("release")
+			if ok {
+				c.setSession("")
+			}
+			json.NewEncoder(w).Encode(ok)
+		default:
+			// Blocking query.
+			if idx, _ := strconv.ParseUint(q.Get("index"), 10, 64); idx >= c.index {
+				changed := c.changed
+				c.mtx.Unlock()
+				select {
+				case <-changed:
+				case <-time.After(time.Second):
+				}
+				c.mtx.Lock()
+			}
+			w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+			if c.session == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode([]consul.KVPair{{
+				Key:     strings.TrimPrefix(r.URL.Path, "/v1/kv/"),
+				Flags:   consul.LockFlagValue,
+				Session: c.session,
+			}})
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestConsulLockReacquire(t *testing.T) {
+	fake := newFakeConsul()
+	s := httptest.NewServer(fake)
+	defer s.Close()
+
+	acquire, err := consulLockAcquirer(strings.TrimPrefix(s.URL, "http://"), "prometheus-scw-sd/leader", log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		lost, err := acquire(ctx)
+		if err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		if lost == nil {
+			t.Fatalf("attempt %d: expected the lock to be acquired", i)
+		}
+		fake.expire()
+		select {
+		case <-lost:
+		case <-ctx.Done():
+			t.Fatalf("attempt %d: expected the lock to be lost", i)
+		}
+	}
+}
+
+func TestLeaderElectorRun(t *testing.T) {
+	acquired := make(chan chan struct{})
+	e := &leaderElector{
+		acquire: func(ctx context.Context) (<-chan struct{}, error) {
+			lost := make(chan struct{})
+			select {
+			case acquired <- lost:
+				return lost, nil
+			case <-ctx.Done():
+				return nil, nil
+			}
+		},
+		elected: make(chan struct{}, 1),
+		logger:  log.NewNopLogger(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+
+	for i := 0; i < 2; i++ {
+		lost := <-acquired
+		<-e.Elected()
+		if !e.IsLeader() {
+			t.Fatalf("attempt %d: expected to be the leader", i)
+		}
+		close(lost)
+	}
+	cancel()
+	<-done
+	if e.IsLeader() {
+		t.Fatal("expected to lose the leadership when stopped")
+	}
+}
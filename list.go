@@ -0,0 +1,115 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// serverSummary is the human-readable description of a target.
+type serverSummary struct {
+	Name           string   `json:"name"`
+	ID             string   `json:"id"`
+	Zone           string   `json:"zone"`
+	State          string   `json:"state"`
+	CommercialType string   `json:"commercial_type"`
+	PublicIP       string   `json:"public_ip"`
+	PrivateIP      string   `json:"private_ip"`
+	Tags           []string `json:"tags"`
+	Target         string   `json:"target"`
+}
+
+var summaryHeader = []string{"NAME", "ID", "ZONE", "STATE", "TYPE", "PUBLIC IP", "PRIVATE IP", "TAGS", "TARGET"}
+
+func (s serverSummary) fields() []string {
+	return []string{s.Name, s.ID, s.Zone, s.State, s.CommercialType, s.PublicIP, s.PrivateIP, strings.Join(s.Tags, ","), s.Target}
+}
+
+// summarize returns the description of the targets of a group.
+func summarize(tg *targetgroup.Group) []serverSummary {
+	label := func(name string) string {
+		return string(tg.Labels[model.LabelName(name)])
+	}
+	tags := []string{}
+	if t := strings.Trim(label(tagsLabel), ","); t != "" {
+		tags = strings.Split(t, ",")
+	}
+	summaries := make([]serverSummary, 0, len(tg.Targets))
+	for _, t := range tg.Targets {
+		summaries = append(summaries, serverSummary{
+			Name:           label(nameLabel),
+			ID:             label(identifierLabel),
+			Zone:           label(zoneLabel),
+			State:          label(stateLabel),
+			CommercialType: label(commercialTypeLabel),
+			PublicIP:       label(publicIPLabel),
+			PrivateIP:      label(privateIPLabel),
+			Tags:           tags,
+			Target:         string(t[model.AddressLabel]),
+		})
+	}
+	return summaries
+}
+
+// listServers performs one discovery pass and prints the targets exported
+// for the servers in the given format.
+func listServers(d *scwDiscoverer, w io.Writer, format string) error {
+	tgs, err := collectTargets(d, nil)
+	if err != nil {
+		return err
+	}
+
+	summaries := make([]serverSummary, 0, len(tgs))
+	for _, tg := range tgs {
+		summaries = append(summaries, summarize(tg)...)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Name != summaries[j].Name {
+			return summaries[i].Name < summaries[j].Name
+		}
+		return summaries[i].Target < summaries[j].Target
+	})
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(summaries)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(summaryHeader)
+		for _, s := range summaries {
+			cw.Write(s.fields())
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(summaryHeader, "\t"))
+		for _, s := range summaries {
+			fmt.Fprintln(tw, strings.Join(s.fields(), "\t"))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/scaleway/prometheus-scw-sd/scwtest"
+)
+
+func TestListServers(t *testing.T) {
+	s := scwtest.NewServer(testToken)
+	defer s.Close()
+	defer s.Use()()
+	setupFleet(s)
+
+	for _, tc := range []struct {
+		args []string
+		want [][]string
+	}{
+		{
+			args: []string{"list", "--format=csv", "--filter.exclude-tags=db"},
+			want: [][]string{
+				summaryHeader,
+				{"web-1", "web-1", "par1", "running", "START1-S", "51.15.0.1", "10.0.0.1", "web,team=front", "10.0.0.1:80"},
+				{"web-2", "web-2", "ams1", "running", "START1-S", "", "10.0.0.2", "web,team=front", "10.0.0.2:80"},
+			},
+		},
+		{
+			args: []string{"list", "--format=csv", "--filter.tags=db", "--target.port=9100"},
+			want: [][]string{
+				summaryHeader,
+				{"db-1", "db-1", "par1", "running", "START1-S", "", "10.0.0.3", "db,team=back", "10.0.0.3:9100"},
+			},
+		},
+		{
+			args: []string{"list", "--format=csv", "--filter.tags=web", "--target.include-transitional", "--filter.exclude-ids=web-1", "--filter.exclude-ids=web-2"},
+			want: [][]string{
+				summaryHeader,
+				{"boot-1", "boot-1", "ams1", "stopping", "START1-S", "", "10.0.0.5", "web", "10.0.0.5:80"},
+			},
+		},
+	} {
+		resetFlags()
+		if _, err := a.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		logger := &scwLogger{log.NewNopLogger()}
+		client, err := newAPIClient(testToken, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		disc, err := newDiscoverer(client, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := listServers(disc, &buf, *listFormat); err != nil {
+			t.Fatal(err)
+		}
+		got, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: expected %v, got %v", tc.args, tc.want, got)
+		}
+	}
+}
@@ -16,9 +16,9 @@ package main
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -35,15 +35,95 @@ import (
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
+// adapterName is the name of the adapter in the service discovery metrics.
+const adapterName = "scalewaySD"
+
 var (
-	a            = kingpin.New("sd adapter usage", "Tool to generate Prometheus file_sd target files for Scaleway.")
-	outputf      = a.Flag("output.file", "The output filename for file_sd compatible file.").Default("scw.json").String()
-	organization = a.Flag("scw.organization", "The Scaleway organization.").Default("").String()
-	region       = a.Flag("scw.region", "The Scaleway region.").Default("").String()
-	tokenf       = a.Flag("scw.token-file", "The authentication token file.").Default("").String()
-	refresh      = a.Flag("target.refresh", "The refresh interval (in seconds).").Default("30").Int()
-	port         = a.Flag("target.port", "The default port number for targets.").Default("80").Int()
-	listen       = a.Flag("web.listen-address", "The listen address.").Default(":9465").String()
+	a              = kingpin.New("sd adapter usage", "Tool to generate Prometheus file_sd target files for Scaleway.")
+	organization   = a.Flag("scw.organization", "The Scaleway organization.").Default("").String()
+	region         = a.Flag("scw.region", "The Scaleway region.").Default("").String()
+	credsProvider  = a.Flag("scw.credentials", "The provider of the Scaleway token (file, env, static or vault).").Default("file").String()
+	tokenf         = a.Flag("scw.token-file", "The authentication token file.").Default("").String()
+	tokenEnv       = a.Flag("scw.token-env", "The environment variable holding the token for the env provider.").Default("SCW_SECRET_KEY").String()
+	staticToken    = a.Flag("scw.token", "The token for the static provider (visible in the process list, prefer the other providers).").Default("").String()
+	vaultAddr      = a.Flag("scw.vault.address", "The address of Vault for the vault provider (VAULT_ADDR by default).").Default("").String()
+	vaultPath      = a.Flag("scw.vault.path", "The path of the Vault secret holding the token (eg secret/data/scaleway).").Default("").String()
+	vaultField     = a.Flag("scw.vault.field", "The field of the Vault secret holding the token.").Default("token").String()
+	vaultTokenFile = a.Flag("scw.vault.token-file", "The file of the token authenticating to Vault (VAULT_TOKEN by default).").Default("").String()
+	socks5         = a.Flag("api.socks5", "The SOCKS5 proxy used to reach the Scaleway API ([user[:password]@]host:port).").Default("").String()
+
+	runCmd       = a.Command("run", "Run the service discovery (default).").Default()
+	refresh      = runCmd.Flag("target.refresh", "The refresh interval of the servers (in seconds).").Default("30").Int()
+	iotRefresh   = runCmd.Flag("iot.refresh", "The refresh interval of the IoT Hubs (in seconds, --target.refresh if 0).").Default("0").Int()
+	apiRefreshes = runCmd.Flag("api.max-concurrent-refreshes", "The maximum number of products refreshed from the API at the same time.").Default("1").Int()
+	ttl          = runCmd.Flag("target.ttl", "The number of refreshes during which a server missing from the API is kept in the targets.").Default("0").Int()
+	minRefreshes = runCmd.Flag("target.min-refreshes", "The number of consecutive refreshes in which a new server must be seen before being added to the targets.").Default("1").Int()
+	minAge       = runCmd.Flag("target.min-age", "The minimum age of a new server before being added to the targets.").Default("0s").Duration()
+	listen       = runCmd.Flag("web.listen-address", "The listen address.").Default(":9465").String()
+	leaderElect  = runCmd.Flag("leader-election.backend", "The leader election backend (none, file or consul).").Default("none").Enum("none", "file", "consul")
+	leaderLock   = runCmd.Flag("leader-election.lock", "The lock file (file backend) or the lock key (consul backend) used for the leader election.").Default("").String()
+	consulAddr   = runCmd.Flag("leader-election.consul-address", "The address of the Consul agent used for the leader election.").Default("localhost:8500").String()
+	hookOnAdd    = runCmd.Flag("hook.on-add", "The command executed when a target is added, with the target's labels as SCW_SD_* environment variables.").Default("").String()
+	hookOnRemove = runCmd.Flag("hook.on-remove", "The command executed when a target is removed, with the target's labels as SCW_SD_* environment variables.").Default("").String()
+	httpPath     = runCmd.Flag("output.http-path", "The HTTP path serving the targets for http_sd (disabled if empty).").Default("").String()
+	auditFile    = runCmd.Flag("audit.file", "The file logging the discovery decisions of the refreshes as JSON lines (disabled if empty).").Default("").String()
+	auditMaxSize = runCmd.Flag("audit.max-size", "The size (in bytes) above which the audit log is rotated.").Default("10485760").Int64()
+	auditMinInt  = runCmd.Flag("audit.min-interval", "The minimum interval between two records of the audit log.").Default("1m").Duration()
+	reloadWatch  = runCmd.Flag("reload.watch", "Reload the token and the filter files when they change, in addition to SIGHUP.").Default("false").Bool()
+	writeOnStart = runCmd.Flag("write-on-start", "Refresh the targets and write the outputs before starting, exiting if it fails.").Default("false").Bool()
+
+	onceCmd = a.Command("once", "Refresh the targets once and exit.")
+
+	listCmd    = a.Command("list", "Print the servers exported by the service discovery.")
+	listFormat = listCmd.Flag("format", "The output format (table, json or csv).").Default("table").Enum("table", "json", "csv")
+
+	validateCmd = a.Command("validate", "Check the Scaleway credentials and exit.")
+
+	diffCmd  = a.Command("diff", "Compare the targets from the Scaleway API with an existing file_sd file.")
+	diffFile = diffCmd.Arg("file", "The file_sd file to compare with (defaults to --output.file).").String()
+
+	genCmd     = a.Command("gen-scrape-config", "Print a Prometheus scrape configuration using the service discovery.")
+	genJobName = genCmd.Flag("job-name", "The job name of the scrape configuration.").Default("scaleway").String()
+	genHTTPURL = genCmd.Flag("http-sd.url", "The URL of the http_sd endpoint to use instead of file_sd.").Default("").String()
+
+	// Flags shared by several commands.
+	outputf          string
+	port             int
+	privateNetwork   string
+	organizations    []string
+	filterTags       []string
+	tagsMatch        string
+	excludeTags      []string
+	excludeMatch     string
+	excludeIDs       []string
+	excludeIDsFile   string
+	s3Bucket         string
+	s3Key            string
+	s3Endpoint       string
+	s3Region         string
+	s3AccessKey      string
+	consulOutAddr    string
+	consulService    string
+	outputHistory    int
+	outputMaxTargets int
+	outputMaxBytes   int
+	inventoryFile    string
+	k8sScrapeCfg     string
+	k8sNamespace     string
+	userdataKey      string
+	probePorts       []int
+	probeTimeout     time.Duration
+	addressName      string
+	reverseDNS       bool
+	reverseDNSTime   time.Duration
+	locationLabels   bool
+	maintLabels      bool
+	transitional     bool
+	checkSecGroups   bool
+	groupByTag       string
+	staticFile       string
+	iotRegions       []string
+	iotPort          int
 
 	scwPrefix = model.MetaLabelPrefix + "scaleway_"
 	// archLabel is the name for the label containing the server's architecture.
@@ -58,14 +138,28 @@ var (
 	imageIDLabel = scwPrefix + "image_id"
 	// imageNameLabel is the name for the label containing the server's image name.
 	imageNameLabel = scwPrefix + "image_name"
+	// imageCreationDateLabel is the name for the label containing the creation date of the server's image.
+	imageCreationDateLabel = scwPrefix + "image_creation_date"
 	// orgLabel is the name for the label containing the server's organization.
 	orgLabel = scwPrefix + "organization"
+	// orgNameLabel is the name for the label containing the server's organization name.
+	orgNameLabel = scwPrefix + "organization_name"
 	// privateIPLabel is the name for the label containing the server's private IP.
 	privateIPLabel = scwPrefix + "private_ip"
 	// publicIPLabel is the name for the label containing the server's public IP.
 	publicIPLabel = scwPrefix + "public_ip"
+	// hostnameLabel is the name for the label containing the server's hostname.
+	hostnameLabel = scwPrefix + "hostname"
+	// reverseDNSLabel is the name for the label containing the name of the server's address.
+	reverseDNSLabel = scwPrefix + "reverse_dns"
 	// stateLabel is the name for the label containing the server's state.
 	stateLabel = scwPrefix + "state"
+	// stateDetailLabel is the name for the label containing the server's detailed state.
+	stateDetailLabel = scwPrefix + "state_detail"
+	// maintenanceStartLabel is the name for the label containing the start of the server's next maintenance.
+	maintenanceStartLabel = scwPrefix + "maintenance_start"
+	// maintenanceReasonLabel is the name for the label containing the reason of the server's next maintenance.
+	maintenanceReasonLabel = scwPrefix + "maintenance_reason"
 	// tagsLabel is the name for the label containing all the server's tags.
 	tagsLabel = scwPrefix + "tags"
 	// platformLabel is the name for the label containing all the server's platform location.
@@ -80,6 +174,8 @@ var (
 	chassisLabel = scwPrefix + "chassis_id"
 	// clusterLabel is the name for the label containing all the server's cluster location.
 	clusterLabel = scwPrefix + "cluster_id"
+	// detectedExporterLabel is the name for the label containing the exporter detected by the probe mode.
+	detectedExporterLabel = scwPrefix + "detected_exporter"
 	// zoneLabel is the name for the label containing all the server's zone location.
 	zoneLabel = scwPrefix + "zone_id"
 )
@@ -99,14 +195,72 @@ var (
 			Help: "Total number of failed requests to the Scaleway API.",
 		},
 	)
+	targetInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			// The metric describes the fleet rather than the service discovery.
+			Name: "scw_sd_target_info",
+			Help: "Information about the servers discovered from the Scaleway API.",
+		},
+		[]string{"server_id", "name", "zone", "commercial_type"},
+	)
 )
 
+// registerSharedFlags adds the flags which are relevant to several commands.
+func registerSharedFlags() {
+	for _, cmd := range []*kingpin.CmdClause{runCmd, onceCmd} {
+		cmd.Flag("output.s3.bucket", "The Object Storage bucket receiving the targets (disabled if empty).").Default("").StringVar(&s3Bucket)
+		cmd.Flag("output.s3.key", "The object key of the targets in the bucket.").Default("scw.json").StringVar(&s3Key)
+		cmd.Flag("output.s3.endpoint", "The Object Storage endpoint.").Default("https://s3.fr-par.scw.cloud").StringVar(&s3Endpoint)
+		cmd.Flag("output.s3.region", "The Object Storage region.").Default("fr-par").StringVar(&s3Region)
+		cmd.Flag("output.s3.access-key", "The access key of the Object Storage (the secret key is the token).").Default("").StringVar(&s3AccessKey)
+		cmd.Flag("output.history", "The number of timestamped copies of the output file to keep (disabled if 0).").Default("0").IntVar(&outputHistory)
+		cmd.Flag("output.inventory-file", "The file receiving the inventory of the discovered servers in JSON (disabled if empty).").Default("").StringVar(&inventoryFile)
+		cmd.Flag("output.consul.address", "The address of the Consul agent registering the targets as services (disabled if empty).").Default("").StringVar(&consulOutAddr)
+		cmd.Flag("output.consul.service", "The name of the Consul service registered for the targets.").Default("scaleway").StringVar(&consulService)
+		cmd.Flag("output.k8s.scrape-config", "The name of the Prometheus Operator ScrapeConfig resource applied in the Kubernetes cluster (disabled if empty).").Default("").StringVar(&k8sScrapeCfg)
+		cmd.Flag("output.k8s.namespace", "The namespace of the ScrapeConfig resource (the namespace of the pod by default).").Default("").StringVar(&k8sNamespace)
+	}
+	for _, cmd := range []*kingpin.CmdClause{runCmd, onceCmd, diffCmd, genCmd} {
+		cmd.Flag("output.file", "The output filename for file_sd compatible file.").Default("scw.json").StringVar(&outputf)
+		cmd.Flag("output.max-targets", "The maximum number of targets per output file, splitting the output into numbered files (eg scw-1.json, disabled if 0).").Default("0").IntVar(&outputMaxTargets)
+		cmd.Flag("output.max-bytes", "The maximum size in bytes of an output file, splitting the output into numbered files (eg scw-1.json, disabled if 0).").Default("0").IntVar(&outputMaxBytes)
+	}
+	for _, cmd := range []*kingpin.CmdClause{runCmd, onceCmd, diffCmd} {
+		cmd.Flag("target.group-by-tag", "The tag key (key=value or key:value tags) whose values group the targets into a single target group per value (disabled if empty).").Default("").StringVar(&groupByTag)
+		cmd.Flag("target.static-file", "A file of extra targets in the file_sd format (JSON or YAML) merged into the outputs.").Default("").StringVar(&staticFile)
+		cmd.Flag("iot.regions", "The region whose IoT Hubs are discovered as probe targets (repeatable, disabled if empty).").StringsVar(&iotRegions)
+		cmd.Flag("iot.port", "The port of the IoT Hub endpoints' targets.").Default("8883").IntVar(&iotPort)
+	}
+	for _, cmd := range []*kingpin.CmdClause{runCmd, onceCmd, diffCmd, listCmd} {
+		cmd.Flag("target.port", "The default port number for targets.").Default("80").IntVar(&port)
+		cmd.Flag("target.address", "The address of the targets (private, public, public-or-private, private-dns or public-dns).").Default("private").StringVar(&addressName)
+		cmd.Flag("private-network", "The name or identifier of the Private Network whose address is used for the targets, leaving out the servers not attached to it (disabled if empty).").Default("").StringVar(&privateNetwork)
+		cmd.Flag("scw.organizations", "The organization to discover (repeatable, all the accessible organizations by default).").StringsVar(&organizations)
+		cmd.Flag("filter.tags", "The tag that the servers must have (repeatable).").StringsVar(&filterTags)
+		cmd.Flag("filter.tags-match", "Whether the servers must have all or any of the --filter.tags tags.").Default("all").EnumVar(&tagsMatch, "all", "any")
+		cmd.Flag("filter.exclude-tags", "The tag that the servers must not have (repeatable).").StringsVar(&excludeTags)
+		cmd.Flag("filter.exclude-ids", "The identifier of a server to exclude (repeatable).").StringsVar(&excludeIDs)
+		cmd.Flag("filter.exclude-ids-file", "A file listing the identifiers of the servers to exclude, one per line.").Default("").StringVar(&excludeIDsFile)
+		cmd.Flag("filter.exclude-tags-match", "Whether the servers are excluded when they have any or all of the --filter.exclude-tags tags.").Default("any").EnumVar(&excludeMatch, "any", "all")
+		cmd.Flag("scw.userdata-key", "The user_data key holding the scrape hints of the servers in JSON (disabled if empty).").Default("").StringVar(&userdataKey)
+		cmd.Flag("probe.ports", "The port probed for an exporter on each server, emitting one target per responding port (repeatable, disabled if empty).").IntsVar(&probePorts)
+		cmd.Flag("probe.timeout", "The timeout of the connection to a probed port.").Default("1s").DurationVar(&probeTimeout)
+		cmd.Flag("target.reverse-dns", "Look up the name of the targets' addresses in the DNS.").Default("false").BoolVar(&reverseDNS)
+		cmd.Flag("target.reverse-dns-timeout", "The timeout of the reverse DNS lookups.").Default("1s").DurationVar(&reverseDNSTime)
+		cmd.Flag("target.include-transitional", "Keep the servers which are starting or stopping in the targets, labeled with their state.").Default("false").BoolVar(&transitional)
+		cmd.Flag("target.check-security-groups", "Drop the targets whose port isn't allowed inbound by the security group of their server.").Default("false").BoolVar(&checkSecGroups)
+		cmd.Flag("target.location-labels", "Attach the servers' physical location (platform, cluster, chassis, blade, node and hypervisor) as labels.").Default("true").BoolVar(&locationLabels)
+		cmd.Flag("target.maintenance-labels", "Attach the next maintenance scheduled on the servers (start and reason) as labels, requesting the servers of every zone again at each refresh.").Default("false").BoolVar(&maintLabels)
+	}
+}
+
 func init() {
 	reg.MustRegister(prometheus.NewProcessCollector(os.Getpid(), ""))
 	reg.MustRegister(prometheus.NewGoCollector())
 	reg.MustRegister(version.NewCollector("prometheus_scaleway_sd"))
 	reg.MustRegister(requestDuration)
 	reg.MustRegister(requestFailures)
+	reg.MustRegister(targetInfo)
 }
 
 type scwLogger struct {
@@ -144,25 +298,114 @@ func (l *scwLogger) Println(v ...interface{}) {
 	level.Error(l).Log("msg", fmt.Sprintln(v...))
 }
 
+// apiTransport sends the requests to the hosts of the Scaleway API (compute,
+// account, IoT Hub, VPC and IPAM) through the --api.socks5 proxy and records their rate
+// limits. The Scaleway client doesn't expose its HTTP client, so apiTransport
+// is installed as the default transport and the requests to the other hosts
+// (Vault, Object Storage, Consul, ...) are passed to the original transport.
+type apiTransport struct {
+	hosts map[string]struct{}
+	api   http.RoundTripper
+	next  http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := t.hosts[req.URL.Host]; ok {
+		return t.api.RoundTrip(req)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// newAPITransport returns the transport of the Scaleway API going through
+// the SOCKS5 proxy at addr, if not empty.
+func newAPITransport(addr string) (*apiTransport, error) {
+	next, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unexpected default HTTP transport %T", http.DefaultTransport)
+	}
+	t := next.Clone()
+	if addr != "" {
+		u, err := url.Parse("socks5://" + addr)
+		if err != nil {
+			return nil, err
+		}
+		if u.Hostname() == "" || u.Port() == "" {
+			return nil, fmt.Errorf("invalid SOCKS5 proxy address %q: expecting host:port", addr)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	hosts := make(map[string]struct{})
+	for _, s := range []string{api.AccountAPI, api.ComputeAPIPar1, api.ComputeAPIAms1, iotAPI, vpcAPI, ipamAPI} {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		hosts[u.Host] = struct{}{}
+	}
+	return &apiTransport{
+		hosts: hosts,
+		api:   &rateLimitTransport{next: t},
+		next:  next,
+	}, nil
+}
+
+// trackedTarget is a target group known by the discoverer.
+type trackedTarget struct {
+	group *targetgroup.Group
+	// seen is the number of consecutive refreshes with the server.
+	seen int
+	// missed is the number of consecutive refreshes without the server.
+	missed int
+	// emitted is true once the target has been sent to the adapter.
+	emitted bool
+}
+
 // scwDiscoverer retrieves target information from the Scaleway API.
 type scwDiscoverer struct {
-	client    *api.ScalewayAPI
-	port      int
-	refresh   int
-	separator string
-	lasts     map[string]struct{}
-	logger    log.Logger
+	client      *api.ScalewayAPI
+	port        int
+	refresh     int
+	ttl         int
+	minSeen     int
+	minAge      time.Duration
+	separator   string
+	userdataKey string
+	// locationLabels is true when the physical location hierarchy is attached to the targets.
+	locationLabels bool
+	// transitional is true when the starting and stopping servers are kept in the targets.
+	transitional bool
+	filter       *serverFilter
+	hooks        *hookRunner
+	scheduler    *apiScheduler
+	// reloadCh receives a value when the token and filter files must be read again.
+	reloadCh <-chan struct{}
+	audit    *auditLog
+	selector AddressSelector
+	cache    *serverCache
+	prober   *prober
+	resolver *reverseResolver
+	// secGroups drops the targets blocked by the security groups (optional).
+	secGroups *securityGroupChecker
+	// maintenances labels the targets with the scheduled maintenances (optional).
+	maintenances *maintenanceChecker
+	// privnet is the selector of --private-network (optional).
+	privnet *privateNetworkSelector
+	// servers are the servers of each zone at its last successful request.
+	servers  map[string][]types.ScalewayServer
+	orgNames map[string]string
+	targets  map[string]*trackedTarget
+	logger   log.Logger
 }
 
-func (d *scwDiscoverer) createTarget(srv *types.ScalewayServer) *targetgroup.Group {
+func (d *scwDiscoverer) createTarget(srv *types.ScalewayServer, addr string) *targetgroup.Group {
 	var tags string
 	if len(srv.Tags) > 0 {
 		tags = d.separator + strings.Join(srv.Tags, d.separator) + d.separator
 	}
 
-	addr := net.JoinHostPort(srv.PrivateIP, fmt.Sprintf("%d", d.port))
-
-	return &targetgroup.Group{
+	tg := &targetgroup.Group{
 		Source: fmt.Sprintf("scaleway/%s", srv.Identifier),
 		Targets: []model.LabelSet{
 			model.LabelSet{
@@ -170,64 +413,305 @@ func (d *scwDiscoverer) createTarget(srv *types.ScalewayServer) *targetgroup.Gro
 			},
 		},
 		Labels: model.LabelSet{
-			model.AddressLabel:                   model.LabelValue(addr),
-			model.LabelName(archLabel):           model.LabelValue(srv.Arch),
-			model.LabelName(commercialTypeLabel): model.LabelValue(srv.CommercialType),
-			model.LabelName(identifierLabel):     model.LabelValue(srv.Identifier),
-			model.LabelName(imageIDLabel):        model.LabelValue(srv.Image.Identifier),
-			model.LabelName(imageNameLabel):      model.LabelValue(srv.Image.Name),
-			model.LabelName(nameLabel):           model.LabelValue(srv.Name),
-			model.LabelName(orgLabel):            model.LabelValue(srv.Organization),
-			model.LabelName(privateIPLabel):      model.LabelValue(srv.PrivateIP),
-			model.LabelName(publicIPLabel):       model.LabelValue(srv.PublicAddress.IP),
-			model.LabelName(stateLabel):          model.LabelValue(srv.State),
-			model.LabelName(tagsLabel):           model.LabelValue(tags),
-			model.LabelName(platformLabel):       model.LabelValue(srv.Location.Platform),
-			model.LabelName(hypervisorLabel):     model.LabelValue(srv.Location.Hypervisor),
-			model.LabelName(nodeLabel):           model.LabelValue(srv.Location.Node),
-			model.LabelName(bladeLabel):          model.LabelValue(srv.Location.Blade),
-			model.LabelName(chassisLabel):        model.LabelValue(srv.Location.Chassis),
-			model.LabelName(clusterLabel):        model.LabelValue(srv.Location.Cluster),
-			model.LabelName(zoneLabel):           model.LabelValue(srv.Location.ZoneID),
+			model.AddressLabel:                      model.LabelValue(addr),
+			model.LabelName(archLabel):              model.LabelValue(srv.Arch),
+			model.LabelName(commercialTypeLabel):    model.LabelValue(srv.CommercialType),
+			model.LabelName(identifierLabel):        model.LabelValue(srv.Identifier),
+			model.LabelName(imageIDLabel):           model.LabelValue(srv.Image.Identifier),
+			model.LabelName(imageNameLabel):         model.LabelValue(srv.Image.Name),
+			model.LabelName(imageCreationDateLabel): model.LabelValue(srv.Image.CreationDate),
+			model.LabelName(nameLabel):              model.LabelValue(srv.Name),
+			model.LabelName(hostnameLabel):          model.LabelValue(srv.Hostname),
+			model.LabelName(orgLabel):               model.LabelValue(srv.Organization),
+			model.LabelName(orgNameLabel):           model.LabelValue(d.orgNames[srv.Organization]),
+			model.LabelName(privateIPLabel):         model.LabelValue(srv.PrivateIP),
+			model.LabelName(publicIPLabel):          model.LabelValue(srv.PublicAddress.IP),
+			model.LabelName(stateLabel):             model.LabelValue(srv.State),
+			model.LabelName(stateDetailLabel):       model.LabelValue(srv.StateDetail),
+			model.LabelName(tagsLabel):              model.LabelValue(tags),
+			model.LabelName(zoneLabel):              model.LabelValue(srv.Location.ZoneID),
 		},
 	}
+	if d.locationLabels {
+		// The hierarchy goes from the platform down to the hypervisor running
+		// the server so that alerts can be correlated per failure domain.
+		tg.Labels[model.LabelName(platformLabel)] = model.LabelValue(srv.Location.Platform)
+		tg.Labels[model.LabelName(clusterLabel)] = model.LabelValue(srv.Location.Cluster)
+		tg.Labels[model.LabelName(chassisLabel)] = model.LabelValue(srv.Location.Chassis)
+		tg.Labels[model.LabelName(bladeLabel)] = model.LabelValue(srv.Location.Blade)
+		tg.Labels[model.LabelName(nodeLabel)] = model.LabelValue(srv.Location.Node)
+		tg.Labels[model.LabelName(hypervisorLabel)] = model.LabelValue(srv.Location.Hypervisor)
+	}
+	return tg
+}
+
+// ready returns true when a new server can be added to the targets.
+func (d *scwDiscoverer) ready(srv *types.ScalewayServer, t *trackedTarget) bool {
+	if t.emitted {
+		return true
+	}
+	if t.seen < d.minSeen {
+		return false
+	}
+	if d.minAge > 0 {
+		created, err := time.Parse(time.RFC3339, srv.CreationDate)
+		if err != nil {
+			level.Warn(d.logger).Log("msg", "invalid creation date", "server", srv.Identifier, "err", err)
+			return true
+		}
+		return time.Since(created) >= d.minAge
+	}
+	return true
+}
+
+// activeServers returns the servers which are running or in a transitional
+// state, leaving out the stopped ones.
+func activeServers(srvs []types.ScalewayServer) *[]types.ScalewayServer {
+	active := make([]types.ScalewayServer, 0, len(srvs))
+	for _, s := range srvs {
+		switch s.State {
+		case "running", "starting", "stopping":
+			active = append(active, s)
+		}
+	}
+	return &active
+}
+
+// updateOrgNames refreshes the names of the organizations accessible with the token.
+func (d *scwDiscoverer) updateOrgNames() {
+	orgs, err := d.client.GetOrganization()
+	if err != nil {
+		level.Warn(d.logger).Log("msg", "failed to get organizations", "err", err)
+		return
+	}
+	names := make(map[string]string, len(orgs.Organizations))
+	for _, o := range orgs.Organizations {
+		names[o.ID] = o.Name
+	}
+	d.orgNames = names
+}
+
+// getServers requests the servers of every zone, the running ones unless the
+// servers in a transitional state are kept too. The servers of a zone which
+// fails are the ones of its last success, and an error is only returned when
+// all the zones fail.
+func (d *scwDiscoverer) getServers() (*[]types.ScalewayServer, error) {
+	var (
+		srvs    []types.ScalewayServer
+		lastErr error
+		failed  int
+	)
+	for _, z := range instanceZones {
+		// The servers of every state are requested since the API client
+		// drops the state parameter of the paginated requests.
+		var res types.ScalewayServers
+		if err := computeRequest(d.client, z, "servers", &res); err != nil {
+			level.Warn(d.logger).Log("msg", "failed to get the servers", "zone", z, "err", err)
+			refreshStatus.failure("instance", z, err)
+			lastErr = err
+			failed++
+			srvs = append(srvs, d.servers[z]...)
+			continue
+		}
+		var zoneSrvs []types.ScalewayServer
+		if d.transitional {
+			zoneSrvs = *activeServers(res.Servers)
+		} else {
+			zoneSrvs = make([]types.ScalewayServer, 0, len(res.Servers))
+			for _, s := range res.Servers {
+				if s.State == "running" {
+					zoneSrvs = append(zoneSrvs, s)
+				}
+			}
+		}
+		for i := range zoneSrvs {
+			zoneSrvs[i].DNSPublic = zoneSrvs[i].Identifier + api.URLPublicDNS
+			zoneSrvs[i].DNSPrivate = zoneSrvs[i].Identifier + api.URLPrivateDNS
+		}
+		refreshStatus.success("instance", z, len(zoneSrvs))
+		d.servers[z] = zoneSrvs
+		srvs = append(srvs, zoneSrvs...)
+	}
+	if failed == len(instanceZones) {
+		return nil, lastErr
+	}
+	return &srvs, nil
 }
 
 func (d *scwDiscoverer) getTargets() ([]*targetgroup.Group, error) {
-	now := time.Now()
-	srvs, err := d.client.GetServers(false, 0)
-	requestDuration.Observe(time.Since(now).Seconds())
+	srvs, err := d.getServers()
 	if err != nil {
-		requestFailures.Inc()
 		return nil, err
 	}
-
 	level.Debug(d.logger).Log("msg", "get servers", "nb", len(*srvs))
 
+	d.updateOrgNames()
+
+	if d.privnet != nil {
+		d.privnet.reset(d.client)
+	}
+
+	var (
+		probeAddrs map[string]map[int]string
+		probed     map[string]struct{}
+	)
+	if d.prober != nil {
+		probeAddrs = make(map[string]map[int]string, len(*srvs))
+		var addrs []string
+		for _, s := range *srvs {
+			if d.filter.reject(&s) != "" {
+				continue
+			}
+			probeAddrs[s.Identifier] = d.prober.addresses(&s, d.selector)
+			for _, addr := range probeAddrs[s.Identifier] {
+				addrs = append(addrs, addr)
+			}
+		}
+		probed = d.prober.probeAll(addrs)
+	}
+
+	if d.resolver != nil {
+		d.resolver.expire()
+		var hosts []string
+		for _, s := range *srvs {
+			if d.filter.reject(&s) != "" {
+				continue
+			}
+			if addr, err := d.selector.Address(&s, d.port); err == nil {
+				host, _, _ := net.SplitHostPort(addr)
+				hosts = append(hosts, host)
+			}
+		}
+		d.resolver.resolve(hosts)
+	}
+	if d.secGroups != nil {
+		d.secGroups.reset(d.client)
+	}
+	if d.maintenances != nil {
+		d.maintenances.reset(d.client)
+	}
+
+	rec := d.audit.record()
+	// ids are the identifiers of the servers turned into targets.
+	ids := make(map[string]struct{}, len(*srvs))
 	current := make(map[string]struct{})
-	tgs := make([]*targetgroup.Group, len(*srvs))
+	tgs := make([]*targetgroup.Group, 0, len(*srvs))
+	targetInfo.Reset()
 	for _, s := range *srvs {
-		tg := d.createTarget(&s)
-		level.Debug(d.logger).Log("msg", "server added", "source", tg.Source)
-		current[tg.Source] = struct{}{}
-		tgs = append(tgs, tg)
+		rec.seen(&s)
+		if rule := d.filter.reject(&s); rule != "" {
+			level.Debug(d.logger).Log("msg", "server filtered out", "server", s.Identifier, "rule", rule)
+			rec.filtered(&s, rule)
+			continue
+		}
+		addr, err := d.selector.Address(&s, d.port)
+		if err != nil {
+			level.Debug(d.logger).Log("msg", "server without address", "server", s.Identifier, "err", err)
+			rec.filtered(&s, "address")
+			continue
+		}
+		targetInfo.WithLabelValues(s.Identifier, s.Name, s.Location.ZoneID, s.CommercialType).Set(1)
+		ids[s.Identifier] = struct{}{}
+		tg := d.cache.get(&s, d.orgNames[s.Organization], addr)
+		if tg == nil {
+			tg = d.createTarget(&s, addr)
+			if d.userdataKey != "" {
+				d.applyHints(&s, tg)
+			}
+			d.cache.set(&s, d.orgNames[s.Organization], addr, tg)
+		}
+		if d.resolver != nil {
+			tg = d.resolver.label(tg)
+		}
+		if d.maintenances != nil {
+			tg = d.maintenances.label(&s, tg)
+		}
+		srvTgs := []*targetgroup.Group{tg}
+		if d.prober != nil {
+			srvTgs = d.prober.expand(tg, probeAddrs[s.Identifier], probed)
+			if len(srvTgs) == 0 {
+				level.Debug(d.logger).Log("msg", "no exporter detected", "server", s.Identifier)
+			}
+		}
+		if d.secGroups != nil {
+			srvTgs = d.secGroups.filter(&s, srvTgs)
+			if len(srvTgs) == 0 {
+				rec.filtered(&s, "security-group")
+				continue
+			}
+		}
+		for _, tg := range srvTgs {
+			current[tg.Source] = struct{}{}
+			t, ok := d.targets[tg.Source]
+			if !ok {
+				t = &trackedTarget{}
+				d.targets[tg.Source] = t
+			}
+			t.group = tg
+			t.seen++
+			t.missed = 0
+			if !d.ready(&s, t) {
+				level.Debug(d.logger).Log("msg", "server pending", "source", tg.Source, "seen", t.seen)
+				rec.pending(tg.Source)
+				continue
+			}
+			level.Debug(d.logger).Log("msg", "server added", "source", tg.Source)
+			if !t.emitted {
+				d.hooks.added(tg)
+			}
+			t.emitted = true
+			rec.emitted(tg.Source)
+			tgs = append(tgs, tg)
+		}
 	}
 
-	// Add empty groups for servers which have been removed since the last refresh.
-	for k := range d.lasts {
-		if _, ok := current[k]; !ok {
-			level.Debug(d.logger).Log("msg", "server deleted", "source", k)
-			tgs = append(tgs, &targetgroup.Group{Source: k})
+	// Keep the servers which have been removed since the last refresh until
+	// their TTL expires, then add empty groups for them.
+	for k, t := range d.targets {
+		if _, ok := current[k]; ok {
+			continue
+		}
+		if !t.emitted {
+			// The server disappeared before being added to the targets.
+			delete(d.targets, k)
+			continue
 		}
+		t.missed++
+		if t.missed <= d.ttl {
+			level.Debug(d.logger).Log("msg", "server missing, keeping target", "source", k, "missed", t.missed)
+			rec.emitted(k)
+			tgs = append(tgs, t.group)
+			continue
+		}
+		level.Debug(d.logger).Log("msg", "server deleted", "source", k)
+		d.hooks.removed(t.group)
+		delete(d.targets, k)
+		rec.removed(k)
+		tgs = append(tgs, &targetgroup.Group{Source: k})
+	}
+	if d.secGroups != nil {
+		d.secGroups.done()
 	}
-	d.lasts = current
+	d.cache.prune(ids)
+	if d.privnet != nil {
+		d.privnet.prune(ids)
+	}
+	d.audit.write(rec)
 
 	return tgs, nil
 }
 
 func (d *scwDiscoverer) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
 	for c := time.Tick(time.Duration(d.refresh) * time.Second); ; {
-		tgs, err := d.getTargets()
+		var (
+			tgs []*targetgroup.Group
+			err error
+		)
+		start := time.Now()
+		if !d.scheduler.do(ctx, "instance", func() { tgs, err = d.getTargets() }) {
+			return
+		}
+		observeRefresh("scaleway", start, err)
 		if err == nil {
 			ch <- tgs
 		}
@@ -235,6 +719,11 @@ func (d *scwDiscoverer) Run(ctx context.Context, ch chan<- []*targetgroup.Group)
 		// Wait for ticker or exit when ctx is closed.
 		select {
 		case <-c:
+			// Pick up the rotated credentials.
+			d.updateToken()
+			continue
+		case <-d.reloadCh:
+			d.reload()
 			continue
 		case <-ctx.Done():
 			return
@@ -242,39 +731,50 @@ func (d *scwDiscoverer) Run(ctx context.Context, ch chan<- []*targetgroup.Group)
 	}
 }
 
-func main() {
-	a.HelpFlag.Short('h')
-
-	a.Version(version.Print("prometheus-scw-sd"))
-
-	_, err := a.Parse(os.Args[1:])
+// readToken returns the token of the --scw.credentials provider.
+func readToken() (string, error) {
+	p, err := getCredentialsProvider(*credsProvider)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-	logger := &scwLogger{
-		log.With(
-			log.NewSyncLogger(log.NewLogfmtLogger(os.Stdout)),
-			"ts", log.DefaultTimestampUTC,
-			"caller", log.DefaultCaller,
-		),
+		return "", err
 	}
+	return p.Token()
+}
 
-	token := ""
+// newClient returns a Scaleway API client with checked credentials.
+func newClient(logger *scwLogger) (*api.ScalewayAPI, error) {
+	token, err := readToken()
+	if err != nil {
+		return nil, err
+	}
 
-	if *tokenf == "" {
-		fmt.Println("need to pass --scw.token-file")
-		os.Exit(1)
+	// The Scaleway client replaces its transport when SCW_TLSVERIFY=0, which
+	// bypasses apiTransport for the compute and account requests.
+	if os.Getenv("SCW_TLSVERIFY") == "0" {
+		if *socks5 != "" {
+			return nil, fmt.Errorf("--api.socks5 can't be used with SCW_TLSVERIFY=0")
+		}
+		level.Warn(logger).Log("msg", "SCW_TLSVERIFY=0: the rate limits of the compute and account APIs aren't recorded")
 	}
-	if *tokenf != "" {
-		b, err := ioutil.ReadFile(*tokenf)
+	if _, ok := http.DefaultTransport.(*apiTransport); !ok {
+		t, err := newAPITransport(*socks5)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return nil, fmt.Errorf("failed to configure the Scaleway API transport: %v", err)
+		}
+		http.DefaultTransport = t
+	}
+	if *socks5 != "" {
+		proxy := *socks5
+		if i := strings.LastIndex(proxy, "@"); i >= 0 {
+			proxy = proxy[i+1:]
 		}
-		token = strings.TrimSpace(strings.TrimRight(string(b), "\n"))
+		level.Info(logger).Log("msg", "Sending the requests to the Scaleway API through the SOCKS5 proxy, the other requests are sent directly", "proxy", proxy)
 	}
 
+	return newAPIClient(token, logger)
+}
+
+// newAPIClient returns a Scaleway API client for the token with checked credentials.
+func newAPIClient(token string, logger *scwLogger) (*api.ScalewayAPI, error) {
 	client, err := api.NewScalewayAPI(
 		*organization,
 		token,
@@ -285,31 +785,316 @@ func main() {
 		},
 	)
 	if err != nil {
-		fmt.Println("failed to create Scaleway API client:", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to create Scaleway API client: %v", err)
 	}
 	err = client.CheckCredentials()
 	if err != nil {
-		fmt.Println("failed to check Scaleway credentials:", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to check Scaleway credentials: %v", err)
 	}
+	return client, nil
+}
+
+// newDiscoverer returns a discoverer configured from the command-line flags.
+func newDiscoverer(client *api.ScalewayAPI, logger log.Logger) (*scwDiscoverer, error) {
+	filter, err := newServerFilter(organizations, filterTags, tagsMatch, excludeTags, excludeMatch, excludeIDs, excludeIDsFile)
+	if err != nil {
+		return nil, err
+	}
+	selector, err := getAddressSelector(addressName)
+	if err != nil {
+		return nil, err
+	}
+	var maintenances *maintenanceChecker
+	if maintLabels {
+		maintenances = newMaintenanceChecker(logger)
+	}
+	var privnet *privateNetworkSelector
+	if privateNetwork != "" {
+		privnet = newPrivateNetworkSelector(privateNetwork)
+		selector = privnet
+	}
+	var resolver *reverseResolver
+	if reverseDNS {
+		resolver = newReverseResolver(reverseDNSTime)
+	}
+	var secGroups *securityGroupChecker
+	if checkSecGroups {
+		secGroups = newSecurityGroupChecker(logger)
+	}
+	return &scwDiscoverer{
+		client:         client,
+		port:           port,
+		refresh:        *refresh,
+		ttl:            *ttl,
+		minSeen:        *minRefreshes,
+		minAge:         *minAge,
+		separator:      ",",
+		userdataKey:    userdataKey,
+		filter:         filter,
+		locationLabels: locationLabels,
+		transitional:   transitional,
+		prober:         newProber(probePorts, probeTimeout),
+		resolver:       resolver,
+		secGroups:      secGroups,
+		maintenances:   maintenances,
+		privnet:        privnet,
+		selector:       selector,
+		cache:          newServerCache(),
+		logger:         logger,
+		servers:        make(map[string][]types.ScalewayServer),
+		targets:        make(map[string]*trackedTarget),
+	}, nil
+}
+
+// newOutputs returns the outputs configured from the command-line flags.
+func newOutputs() ([]output, error) {
+	var outputs []output
+	if outputf != "" {
+		outputs = append(outputs, newFileOutput(outputf, outputHistory, outputMaxTargets, outputMaxBytes))
+	}
+	if inventoryFile != "" {
+		outputs = append(outputs, newInventoryOutput(inventoryFile))
+	}
+	if s3Bucket != "" {
+		if s3AccessKey == "" {
+			return nil, fmt.Errorf("need to pass --output.s3.access-key")
+		}
+		// The secret key is the token of the --scw.credentials provider.
+		p, err := getCredentialsProvider(*credsProvider)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, newS3Output(s3Endpoint, s3Region, s3Bucket, s3Key, s3AccessKey, p))
+	}
+	if consulOutAddr != "" {
+		o, err := newConsulOutput(consulOutAddr, consulService)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, o)
+	}
+	if k8sScrapeCfg != "" {
+		o, err := newScrapeConfigOutput(k8sScrapeCfg, k8sNamespace)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, o)
+	}
+	return outputs, nil
+}
 
+// runDaemon refreshes the targets periodically until the process exits.
+func runDaemon(client *api.ScalewayAPI, logger *scwLogger) {
 	ctx := context.Background()
-	disc := &scwDiscoverer{
-		client:    client,
-		port:      *port,
-		refresh:   *refresh,
-		separator: ",",
-		logger:    logger,
-		lasts:     make(map[string]struct{}),
-	}
-	sdAdapter := NewAdapter(ctx, *outputf, "scalewaySD", disc, logger)
+	disc, err := newDiscoverer(client, logger)
+	if err != nil {
+		fmt.Println("failed to configure the discovery:", err)
+		os.Exit(1)
+	}
+	disc.hooks = newHookRunner(*hookOnAdd, *hookOnRemove, logger)
+	disc.audit = newAuditLog(*auditFile, *auditMaxSize, *auditMinInt, logger)
+	if disc.hooks != nil {
+		go disc.hooks.Run(ctx)
+	}
+	leader, err := newLeaderElector(*leaderElect, *leaderLock, *consulAddr, logger)
+	if err != nil {
+		fmt.Println("failed to configure the leader election:", err)
+		os.Exit(1)
+	}
+	outputs, err := newOutputs()
+	if err != nil {
+		fmt.Println("failed to configure the outputs:", err)
+		os.Exit(1)
+	}
+	if *httpPath != "" {
+		h := newHTTPOutput()
+		outputs = append(outputs, h)
+		http.Handle(*httpPath, h)
+	}
+	if len(outputs) == 0 {
+		fmt.Println("no output configured")
+		os.Exit(1)
+	}
+	scheduler := newAPIScheduler(*apiRefreshes)
+	disc.scheduler = scheduler
+	reloader := newReloader(*reloadWatch, []string{*tokenf, excludeIDsFile}, logger)
+	disc.reloadCh = reloader.subscribe()
+	sdAdapter, err := newAdapter(ctx, outputs, disc, leader, logger)
+	if err != nil {
+		fmt.Println("failed to read the static targets:", err)
+		os.Exit(1)
+	}
+	iotInterval := *iotRefresh
+	if iotInterval == 0 {
+		iotInterval = *refresh
+	}
+	iot := newIoTDiscoverer(client.Token, iotRegions, iotPort, iotInterval, disc.filter.orgs, logger)
+	if iot != nil {
+		iot.scheduler = scheduler
+		iot.reloadCh = reloader.subscribe()
+		sdAdapter.AddDiscoverer("scalewayIoT", iot)
+	}
+	if *writeOnStart {
+		if leader != nil {
+			fmt.Println("--write-on-start can't be used with the leader election")
+			os.Exit(1)
+		}
+		n, err := writeInitialTargets(sdAdapter, disc, iot)
+		if err != nil {
+			fmt.Println("failed to write the initial targets:", err)
+			os.Exit(1)
+		}
+		level.Info(logger).Log("msg", "initial targets written", "groups", n)
+	}
+	go reloader.Run(ctx)
 	sdAdapter.Run()
 
 	level.Debug(logger).Log("msg", "listening for connections", "addr", *listen)
 	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{ErrorLog: logger}))
+	http.Handle("/status", refreshStatus)
 	if err := http.ListenAndServe(*listen, nil); err != nil {
 		level.Debug(logger).Log("msg", "failed to listen", "addr", *listen, "err", err)
 		os.Exit(1)
 	}
 }
+
+// collectTargets refreshes the targets of all the products once. The IoT
+// discoverer is optional. A single pass can't wait for the new servers to be
+// seen several times, so --target.min-refreshes only applies to the refreshes
+// which follow.
+func collectTargets(disc *scwDiscoverer, iot *iotDiscoverer) ([]*targetgroup.Group, error) {
+	minSeen := disc.minSeen
+	disc.minSeen = 1
+	defer func() { disc.minSeen = minSeen }()
+	tgs, err := disc.getTargets()
+	if err != nil {
+		return nil, err
+	}
+	if iot != nil {
+		hubs, err := iot.getTargets()
+		if err != nil {
+			return nil, err
+		}
+		tgs = append(tgs, hubs...)
+	}
+	return tgs, nil
+}
+
+// writeInitialTargets refreshes the targets once and writes the outputs
+// before the adapter runs. It returns the number of target groups written.
+func writeInitialTargets(a *Adapter, disc *scwDiscoverer, iot *iotDiscoverer) (int, error) {
+	tgs, err := collectTargets(disc, iot)
+	if err != nil {
+		return 0, err
+	}
+	return len(tgs), a.WriteOnce(tgs)
+}
+
+// newAdapter returns the adapter of the discoverer configured from the
+// command-line flags. The leader elector is optional.
+func newAdapter(ctx context.Context, outputs []output, disc *scwDiscoverer, leader *leaderElector, logger log.Logger) (*Adapter, error) {
+	static, err := newStaticTargets(staticFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	sdAdapter := NewAdapter(ctx, outputs, adapterName, disc, leader, logger)
+	sdAdapter.groupByTag = groupByTag
+	sdAdapter.static = static
+	return sdAdapter, nil
+}
+
+// runOnce refreshes the targets and writes the output file.
+func runOnce(client *api.ScalewayAPI, logger *scwLogger) error {
+	disc, err := newDiscoverer(client, logger)
+	if err != nil {
+		return err
+	}
+	tgs, err := collectTargets(disc, newIoTDiscoverer(client.Token, iotRegions, iotPort, *refresh, disc.filter.orgs, logger))
+	if err != nil {
+		return err
+	}
+	outputs, err := newOutputs()
+	if err != nil {
+		return err
+	}
+	if len(outputs) == 0 {
+		return fmt.Errorf("no output configured")
+	}
+	sdAdapter, err := newAdapter(context.Background(), outputs, disc, nil, logger)
+	if err != nil {
+		return err
+	}
+	return sdAdapter.WriteOnce(tgs)
+}
+
+func main() {
+	a.HelpFlag.Short('h')
+	registerSharedFlags()
+
+	a.Version(version.Print("prometheus-scw-sd"))
+
+	cmd, err := a.Parse(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Commands printing to stdout send their logs to stderr.
+	logw := os.Stdout
+	if cmd == listCmd.FullCommand() || cmd == diffCmd.FullCommand() || cmd == validateCmd.FullCommand() {
+		logw = os.Stderr
+	}
+	logger := &scwLogger{
+		log.With(
+			log.NewSyncLogger(log.NewLogfmtLogger(logw)),
+			"ts", log.DefaultTimestampUTC,
+			"caller", log.DefaultCaller,
+		),
+	}
+
+	if cmd == genCmd.FullCommand() {
+		files := newFileOutput(outputf, 0, outputMaxTargets, outputMaxBytes).pattern()
+		if err := genScrapeConfig(os.Stdout, *genJobName, files, *genHTTPURL); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to generate the scrape configuration:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	client, err := newClient(logger)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	switch cmd {
+	case validateCmd.FullCommand():
+		fmt.Println("the Scaleway credentials are valid")
+	case onceCmd.FullCommand():
+		if err := runOnce(client, logger); err != nil {
+			fmt.Println("failed to refresh the targets:", err)
+			os.Exit(1)
+		}
+	case listCmd.FullCommand():
+		disc, err := newDiscoverer(client, logger)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to configure the discovery:", err)
+			os.Exit(1)
+		}
+		if err := listServers(disc, os.Stdout, *listFormat); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to list servers:", err)
+			os.Exit(1)
+		}
+	case diffCmd.FullCommand():
+		file := *diffFile
+		if file == "" {
+			file = outputf
+		}
+		if err := runDiff(client, logger, file, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to compare targets:", err)
+			os.Exit(1)
+		}
+	default:
+		runDaemon(client, logger)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/scaleway/prometheus-scw-sd/scwtest"
+)
+
+func TestAPITransport(t *testing.T) {
+	s := scwtest.NewServer(testToken)
+	defer s.Close()
+	defer s.Use()()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+
+	// The proxy accepts the connections and closes them right away.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	proxied := make(chan struct{}, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			proxied <- struct{}{}
+			c.Close()
+		}
+	}()
+
+	tr, err := newAPITransport(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: tr}
+
+	if _, err := client.Get(s.AccountURL() + "/tokens/" + testToken); err == nil {
+		t.Fatal("expected the Scaleway API request to fail through the proxy")
+	}
+	if len(proxied) == 0 {
+		t.Fatal("expected the Scaleway API request to go through the proxy")
+	}
+	n := len(proxied)
+	resp, err := client.Get(other.URL)
+	if err != nil {
+		t.Fatalf("expected the other request to bypass the proxy, got %v", err)
+	}
+	resp.Body.Close()
+	if len(proxied) != n {
+		t.Error("expected the other request to bypass the proxy")
+	}
+}
+
+func TestAPITransportInvalidProxy(t *testing.T) {
+	for _, addr := range []string{"bastion", "user@:1080"} {
+		if _, err := newAPITransport(addr); err == nil {
+			t.Errorf("%s: expected an error", addr)
+		}
+	}
+}
+
+func TestWriteInitialTargets(t *testing.T) {
+	s := scwtest.NewServer(testToken)
+	defer s.Close()
+	defer s.Use()()
+	setupFleet(s)
+
+	resetFlags()
+	if _, err := a.Parse([]string{"run", "--write-on-start", "--target.min-refreshes=3"}); err != nil {
+		t.Fatal(err)
+	}
+	logger := &scwLogger{log.NewNopLogger()}
+	client, err := newAPIClient(testToken, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disc, err := newDiscoverer(client, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &memoryOutput{name: "file"}
+	sdAdapter := NewAdapter(context.Background(), []output{out}, adapterName, disc, nil, logger)
+
+	n, err := writeInitialTargets(sdAdapter, disc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || !strings.Contains(string(out.content), "10.0.0.1:80") {
+		t.Errorf("expected the 3 running servers to be written, got %d groups: %s", n, out.content)
+	}
+	if disc.minSeen != 3 {
+		t.Errorf("expected --target.min-refreshes to apply after the first pass, got %d", disc.minSeen)
+	}
+}
+
+// newTestDiscoverer returns a discoverer of the fake API configured with the
+// arguments of the run command.
+func newTestDiscoverer(t *testing.T, args ...string) *scwDiscoverer {
+	resetFlags()
+	if _, err := a.Parse(append([]string{"run"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	logger := &scwLogger{log.NewNopLogger()}
+	client, err := newAPIClient(testToken, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disc, err := newDiscoverer(client, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return disc
+}
+
+// countTargets returns the number of groups and targets of a refresh.
+func countTargets(t *testing.T, disc *scwDiscoverer) (int, int) {
+	tgs, err := disc.getTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, tg := range tgs {
+		n += len(tg.Targets)
+	}
+	return len(tgs), n
+}
+
+func TestDiscovererTTL(t *testing.T) {
+	s := scwtest.NewServer(testToken)
+	defer s.Close()
+	defer s.Use()()
+	s.SetServers(testServer("web-1", "par1", "running", "10.0.0.1"))
+	disc := newTestDiscoverer(t, "--target.ttl=1")
+
+	for i, tc := range []struct {
+		remove  bool
+		groups  int
+		targets int
+	}{
+		{groups: 1, targets: 1},
+		// The removed server is kept for one refresh, then an empty group
+		// removes it.
+		{remove: true, groups: 1, targets: 1},
+		{groups: 1, targets: 0},
+		{groups: 0},
+	} {
+		if tc.remove {
+			s.RemoveServer("web-1")
+		}
+		if groups, targets := countTargets(t, disc); groups != tc.groups || targets != tc.targets {
+			t.Errorf("refresh %d: expected %d groups with %d targets, got %d groups with %d targets", i+1, tc.groups, tc.targets, groups, targets)
+		}
+	}
+}
+
+func TestDiscovererMinRefreshes(t *testing.T) {
+	s := scwtest.NewServer(testToken)
+	defer s.Close()
+	defer s.Use()()
+	s.SetServers(testServer("web-1", "par1", "running", "10.0.0.1"))
+	disc := newTestDiscoverer(t, "--target.min-refreshes=2")
+
+	// The new server is pending until the second refresh.
+	for i, want := range []int{0, 1, 1} {
+		if _, targets := countTargets(t, disc); targets != want {
+			t.Errorf("refresh %d: expected %d targets, got %d", i+1, want, targets)
+		}
+	}
+
+	// A server disappearing before being added is forgotten.
+	s.AddServer(testServer("web-2", "par1", "running", "10.0.0.2"))
+	countTargets(t, disc)
+	s.RemoveServer("web-2")
+	countTargets(t, disc)
+	s.AddServer(testServer("web-2", "par1", "running", "10.0.0.2"))
+	if _, targets := countTargets(t, disc); targets != 1 {
+		t.Errorf("expected web-2 to be pending again, got %d targets", targets)
+	}
+}
+
+func TestDiscovererZoneFailure(t *testing.T) {
+	s := scwtest.NewServer(testToken)
+	defer s.Close()
+	defer s.Use()()
+	s.SetServers(
+		testServer("web-1", "par1", "running", "10.0.0.1"),
+		testServer("web-2", "ams1", "running", "10.0.0.2"),
+	)
+	disc := newTestDiscoverer(t)
+	if _, targets := countTargets(t, disc); targets != 2 {
+		t.Fatalf("expected 2 targets, got %d", targets)
+	}
+
+	// The servers of par1 are kept while ams1 is still refreshed.
+	s.FailNext(scwtest.Servers, http.StatusInternalServerError, 1)
+	s.AddServer(testServer("web-3", "ams1", "running", "10.0.0.3"))
+	if _, targets := countTargets(t, disc); targets != 3 {
+		t.Errorf("expected 3 targets, got %d", targets)
+	}
+	refreshStatus.mtx.Lock()
+	par1, ams1 := *refreshStatus.get("instance", "par1"), *refreshStatus.get("instance", "ams1")
+	refreshStatus.mtx.Unlock()
+	if par1.ConsecutiveFailures != 1 {
+		t.Errorf("expected 1 failure in par1, got %d", par1.ConsecutiveFailures)
+	}
+	if ams1.ConsecutiveFailures != 0 || ams1.Found != 2 {
+		t.Errorf("expected 2 servers found in ams1, got %d (failures: %d)", ams1.Found, ams1.ConsecutiveFailures)
+	}
+
+	// The refresh fails when all the zones fail.
+	s.FailNext(scwtest.Servers, http.StatusInternalServerError, 2)
+	if _, err := disc.getTargets(); err == nil {
+		t.Error("expected an error when all the zones fail")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	api "github.com/scaleway/go-scaleway"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+// maintenance is a maintenance scheduled by Scaleway on a server.
+type maintenance struct {
+	Reason    string `json:"reason"`
+	StartDate string `json:"start_date"`
+}
+
+// maintenanceChecker labels the targets with the next scheduled maintenance
+// of their server. The maintenances aren't exposed by the API client, so the
+// servers of every zone are requested again from the compute API, once per
+// refresh.
+type maintenanceChecker struct {
+	// client is the client of the current refresh.
+	client *api.ScalewayAPI
+	// listed are the zones already requested during the current refresh.
+	listed map[string]bool
+	// windows holds the maintenance of the servers per zone. The previous
+	// maintenances of a zone are kept when the API fails.
+	windows map[string]map[string]maintenance
+	logger  log.Logger
+}
+
+func newMaintenanceChecker(logger log.Logger) *maintenanceChecker {
+	return &maintenanceChecker{
+		windows: make(map[string]map[string]maintenance),
+		logger:  logger,
+	}
+}
+
+// reset starts a new refresh with the given client.
+func (c *maintenanceChecker) reset(client *api.ScalewayAPI) {
+	c.client = client
+	c.listed = make(map[string]bool)
+}
+
+// list requests the maintenances of the servers of the zone.
+func (c *maintenanceChecker) list(zone string) error {
+	var res struct {
+		Servers []struct {
+			ID           string        `json:"id"`
+			Maintenances []maintenance `json:"maintenances"`
+		} `json:"servers"`
+	}
+	if err := computeRequest(c.client, zone, "servers", &res); err != nil {
+		return err
+	}
+	windows := make(map[string]maintenance)
+	for _, srv := range res.Servers {
+		for _, m := range srv.Maintenances {
+			// The dates are in the RFC 3339 format, the earliest comes first.
+			if w, ok := windows[srv.ID]; !ok || (m.StartDate != "" && (w.StartDate == "" || m.StartDate < w.StartDate)) {
+				windows[srv.ID] = m
+			}
+		}
+	}
+	c.windows[zone] = windows
+	return nil
+}
+
+// label returns the target group of the server with the maintenance labels.
+// The given group isn't modified since it may be cached.
+func (c *maintenanceChecker) label(srv *types.ScalewayServer, tg *targetgroup.Group) *targetgroup.Group {
+	zone := srv.Location.ZoneID
+	if !c.listed[zone] {
+		c.listed[zone] = true
+		if err := c.list(zone); err != nil {
+			level.Warn(c.logger).Log("msg", "failed to get the maintenances", "zone", zone, "err", err)
+		}
+	}
+	m := c.windows[zone][srv.Identifier]
+	labels := tg.Labels.Clone()
+	labels[model.LabelName(maintenanceStartLabel)] = model.LabelValue(m.StartDate)
+	labels[model.LabelName(maintenanceReasonLabel)] = model.LabelValue(m.Reason)
+	return &targetgroup.Group{
+		Source:  tg.Source,
+		Targets: tg.Targets,
+		Labels:  labels,
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/scaleway/prometheus-scw-sd/scwtest"
+)
+
+func TestMaintenanceChecker(t *testing.T) {
+	s := scwtest.NewServer(testToken)
+	defer s.Close()
+	defer s.Use()()
+	setupFleet(s)
+	s.AddMaintenance("web-1", scwtest.Maintenance{Reason: "hypervisor upgrade", StartDate: "2018-05-03T02:00:00Z"})
+
+	client, err := newAPIClient(testToken, &scwLogger{log.NewNopLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newMaintenanceChecker(log.NewNopLogger())
+	web := testServer("web-1", "par1", "running", "10.0.0.1")
+	db := testServer("db-1", "par1", "running", "10.0.0.3")
+	cached := testGroup("scaleway/web-1", "10.0.0.1:80", model.LabelSet{model.LabelName(nameLabel): "web-1"})
+
+	check := func(srv string, labels model.LabelSet, start string) {
+		t.Helper()
+		if got := labels[model.LabelName(maintenanceStartLabel)]; string(got) != start {
+			t.Errorf("%s: expected the maintenance to start at %q, got %q", srv, start, got)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		c.reset(client)
+		if i == 1 {
+			// The previous maintenances are kept when the API fails.
+			s.FailNext(scwtest.Servers, http.StatusInternalServerError, 1)
+		}
+		tg := c.label(&web, cached)
+		check("web-1", tg.Labels, "2018-05-03T02:00:00Z")
+		tg = c.label(&db, testGroup("scaleway/db-1", "10.0.0.3:80", model.LabelSet{}))
+		check("db-1", tg.Labels, "")
+	}
+	if n := s.Requests(scwtest.Servers); n != 2 {
+		t.Errorf("expected 1 request per refresh, got %d", n)
+	}
+	if _, ok := cached.Labels[model.LabelName(maintenanceStartLabel)]; ok {
+		t.Error("expected the cached group not to be modified")
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	outputWrites = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "prometheus_scaleway_sd_output_writes_total",
+			Help: "Total number of writes to the outputs.",
+		},
+		[]string{"output"},
+	)
+	outputFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "prometheus_scaleway_sd_output_write_failures_total",
+			Help: "Total number of failed writes to the outputs.",
+		},
+		[]string{"output"},
+	)
+)
+
+func init() {
+	reg.MustRegister(outputWrites)
+	reg.MustRegister(outputFailures)
+}
+
+// output is a destination for the JSON formatted targets.
+type output interface {
+	// Name identifies the output in logs and metrics.
+	Name() string
+	// Write replaces the targets of the output.
+	Write(b []byte) error
+}
+
+// loader is implemented by the outputs which can read back the last written targets.
+type loader interface {
+	// Load returns nil if the output doesn't exist yet.
+	Load() ([]byte, error)
+}
+
+// groupsOutput is implemented by the outputs which are written from the
+// discovered groups, before their grouping by tag and the merge of the static
+// targets, rather than from the JSON formatted targets.
+type groupsOutput interface {
+	// WriteGroups replaces the discovered groups of the output.
+	WriteGroups(groups []customSD) error
+}
+
+// localOutput is implemented by the outputs private to the process, which
+// are written by all the replicas and not only by the leader.
+type localOutput interface {
+	// Local returns true if the output is private to the process.
+	Local() bool
+}
+
+// fileOutput writes the targets to a file for file_sd.
+type fileOutput struct {
+	path string
+	// history is the number of timestamped copies of the file kept.
+	history int
+	// maxTargets and maxBytes split the targets into numbered chunk files
+	// when one of them is positive.
+	maxTargets int
+	maxBytes   int
+}
+
+func newFileOutput(path string, history, maxTargets, maxBytes int) *fileOutput {
+	return &fileOutput{path: path, history: history, maxTargets: maxTargets, maxBytes: maxBytes}
+}
+
+// split returns true if the targets are written to chunk files.
+func (f *fileOutput) split() bool {
+	return f.maxTargets > 0 || f.maxBytes > 0
+}
+
+// chunkPath returns the path of the i-th chunk file (eg scw-1.json for scw.json).
+func (f *fileOutput) chunkPath(i int) string {
+	ext := filepath.Ext(f.path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(f.path, ext), i, ext)
+}
+
+// pattern returns the path of the file, or the glob matching the chunk files
+// when the targets are split (eg scw-*.json).
+func (f *fileOutput) pattern() string {
+	if !f.split() {
+		return f.path
+	}
+	ext := filepath.Ext(f.path)
+	return fmt.Sprintf("%s-*%s", strings.TrimSuffix(f.path, ext), ext)
+}
+
+// Name implements the output interface.
+func (f *fileOutput) Name() string {
+	return "file"
+}
+
+// Load implements the loader interface.
+func (f *fileOutput) Load() ([]byte, error) {
+	if f.split() {
+		return f.loadChunks()
+	}
+	b, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// loadChunks returns the targets of all the chunk files.
+func (f *fileOutput) loadChunks() ([]byte, error) {
+	var groups []json.RawMessage
+	for i := 1; ; i++ {
+		b, err := ioutil.ReadFile(f.chunkPath(i))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var chunk []json.RawMessage
+		if err := json.Unmarshal(b, &chunk); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", f.chunkPath(i), err)
+		}
+		groups = append(groups, chunk...)
+	}
+	if groups == nil {
+		return nil, nil
+	}
+	return json.Marshal(groups)
+}
+
+// Write implements the output interface.
+func (f *fileOutput) Write(b []byte) error {
+	if f.split() {
+		if err := f.writeChunks(b); err != nil {
+			return err
+		}
+	} else {
+		if err := writeFile(f.path, b); err != nil {
+			return err
+		}
+		f.setMtime(f.path)
+	}
+	if f.history > 0 {
+		return f.rotate(b)
+	}
+	return nil
+}
+
+func (f *fileOutput) setMtime(path string) {
+	if fi, err := os.Stat(path); err == nil {
+		sdFileMtime.WithLabelValues(path).Set(float64(fi.ModTime().UnixNano()) / 1e9)
+	}
+}
+
+// writeChunks splits the targets into chunk files of at most maxTargets
+// targets and maxBytes bytes, and removes the chunk files left over by a
+// previous write. A group exceeding the limits on its own is written to its
+// own chunk file.
+func (f *fileOutput) writeChunks(b []byte) error {
+	var groups []customSD
+	if err := json.Unmarshal(b, &groups); err != nil {
+		return err
+	}
+
+	var (
+		chunks  [][]customSD
+		current []customSD
+		targets int
+		size    int
+	)
+	for _, g := range groups {
+		enc, err := json.MarshalIndent(g, "    ", "    ")
+		if err != nil {
+			return err
+		}
+		// Account for the indentation and the separator of the group in the array.
+		n := len(enc) + 6
+		if len(current) > 0 &&
+			((f.maxTargets > 0 && targets+len(g.Targets) > f.maxTargets) ||
+				(f.maxBytes > 0 && size+n+4 > f.maxBytes)) {
+			chunks = append(chunks, current)
+			current, targets, size = nil, 0, 0
+		}
+		current = append(current, g)
+		targets += len(g.Targets)
+		size += n
+	}
+	if len(current) > 0 || len(chunks) == 0 {
+		// An empty chunk file keeps the glob matching when there is no target.
+		chunks = append(chunks, current)
+	}
+
+	for i, c := range chunks {
+		if c == nil {
+			c = []customSD{}
+		}
+		buf := getBuffer()
+		encodeGroups(buf, c)
+		err := writeFile(f.chunkPath(i+1), buf.Bytes())
+		putBuffer(buf)
+		if err != nil {
+			return err
+		}
+		f.setMtime(f.chunkPath(i + 1))
+	}
+	for i := len(chunks) + 1; ; i++ {
+		err := os.Remove(f.chunkPath(i))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sdFileMtime.DeleteLabelValues(f.chunkPath(i))
+	}
+}
+
+// writeFile replaces the content of the file atomically.
+func writeFile(path string, b []byte) error {
+	dir, _ := filepath.Split(path)
+	tmpfile, err := ioutil.TempFile(dir, "sd-adapter")
+	if err != nil {
+		return err
+	}
+	defer tmpfile.Close()
+
+	_, err = tmpfile.Write(b)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpfile.Name(), path)
+}
+
+// rotate writes a timestamped copy of the targets and deletes the oldest
+// copies beyond the history size.
+func (f *fileOutput) rotate(b []byte) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err := ioutil.WriteFile(f.path+"."+now, b, 0644); err != nil {
+		return err
+	}
+
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	var copies []string
+	for _, m := range matches {
+		if _, err := time.Parse(time.RFC3339, strings.TrimPrefix(m, f.path+".")); err == nil {
+			copies = append(copies, m)
+		}
+	}
+	if len(copies) <= f.history {
+		return nil
+	}
+	// The timestamps are in UTC so that the lexical order is the chronological one.
+	sort.Strings(copies)
+	for _, c := range copies[:len(copies)-f.history] {
+		if err := os.Remove(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// httpOutput serves the targets to Prometheus' http_sd.
+type httpOutput struct {
+	mtx     sync.RWMutex
+	content []byte
+}
+
+func newHTTPOutput() *httpOutput {
+	return &httpOutput{content: []byte("[]")}
+}
+
+// Name implements the output interface.
+func (h *httpOutput) Name() string {
+	return "http"
+}
+
+// Local implements the localOutput interface: every replica serves its targets.
+func (h *httpOutput) Local() bool {
+	return true
+}
+
+// Write implements the output interface.
+func (h *httpOutput) Write(b []byte) error {
+	h.mtx.Lock()
+	h.content = b
+	h.mtx.Unlock()
+	return nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *httpOutput) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mtx.RLock()
+	b := h.content
+	h.mtx.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// s3Output uploads the targets to an S3-compatible bucket such as Scaleway Object Storage.
+type s3Output struct {
+	url       string
+	region    string
+	accessKey string
+	// secretKey provides the secret key, read at every write so that the
+	// rotated tokens are picked up.
+	secretKey CredentialsProvider
+	client    *http.Client
+}
+
+func newS3Output(endpoint, region, bucket, key, accessKey string, secretKey CredentialsProvider) *s3Output {
+	return &s3Output{
+		url:       fmt.Sprintf("%s/%s/%s", strings.TrimRight(endpoint, "/"), bucket, strings.TrimLeft(key, "/")),
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Name implements the output interface.
+func (s *s3Output) Name() string {
+	return "s3"
+}
+
+// Write implements the output interface.
+func (s *s3Output) Write(b []byte) error {
+	body := bytes.NewReader(b)
+	req, err := http.NewRequest("PUT", s.url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	secretKey, err := s.secretKey.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read the secret key: %v", err)
+	}
+	signer := v4.NewSigner(credentials.NewStaticCredentials(s.accessKey, secretKey, ""))
+	if _, err := signer.Sign(req, body, "s3", s.region, time.Now()); err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+func TestS3OutputRotatedSecret(t *testing.T) {
+	secret := "secret-1"
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Sign the same request with the current secret.
+		body, _ := ioutil.ReadAll(r.Body)
+		date, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.Path, nil)
+		req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+		signer := v4.NewSigner(credentials.NewStaticCredentials("SCWACCESS", secret, ""))
+		if _, err := signer.Sign(req, bytes.NewReader(body), "s3", "fr-par", date); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := r.Header.Get("Authorization"), req.Header.Get("Authorization"); got != want {
+			t.Errorf("expected the request to be signed with %q: expected %q, got %q", secret, want, got)
+		}
+	}))
+	defer s.Close()
+
+	o := newS3Output(s.URL, "fr-par", "bucket", "scw.json", "SCWACCESS", CredentialsProviderFunc(func() (string, error) {
+		if secret == "" {
+			return "", fmt.Errorf("no token")
+		}
+		return secret, nil
+	}))
+	for _, secret = range []string{"secret-1", "secret-2"} {
+		if err := o.Write([]byte("[]")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	secret = ""
+	if err := o.Write([]byte("[]")); err == nil {
+		t.Error("expected an error when the secret can't be read")
+	}
+}
+
+// testGroups returns n groups of one target each.
+func testGroups(n int) []customSD {
+	groups := make([]customSD, n)
+	for i := range groups {
+		groups[i] = customSD{
+			Targets: []string{fmt.Sprintf("10.0.0.%d:80", i+1)},
+			Labels:  map[string]string{nameLabel: fmt.Sprintf("web-%d", i+1)},
+		}
+	}
+	return groups
+}
+
+// readGroups returns the groups of a file_sd file.
+func readGroups(t *testing.T, path string) []customSD {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups []customSD
+	if err := json.Unmarshal(b, &groups); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return groups
+}
+
+func TestFileOutputChunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prometheus-scw-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := newFileOutput(filepath.Join(dir, "scw.json"), 0, 2, 0)
+
+	for _, tc := range []struct {
+		groups int
+		chunks []int
+	}{
+		{groups: 5, chunks: []int{2, 2, 1}},
+		// The chunk files left over by the previous write are removed.
+		{groups: 3, chunks: []int{2, 1}},
+		// An empty chunk file is written when there is no target.
+		{groups: 0, chunks: []int{0}},
+	} {
+		groups := testGroups(tc.groups)
+		b, err := json.Marshal(groups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		matches, err := filepath.Glob(f.pattern())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != len(tc.chunks) {
+			t.Fatalf("%d groups: expected %d chunk files, got %v", tc.groups, len(tc.chunks), matches)
+		}
+		for i, n := range tc.chunks {
+			if got := readGroups(t, f.chunkPath(i+1)); len(got) != n {
+				t.Errorf("%d groups: expected %d groups in chunk %d, got %d", tc.groups, n, i+1, len(got))
+			}
+		}
+
+		b, err = f.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.groups == 0 {
+			if b != nil {
+				t.Errorf("expected to load no target, got %s", b)
+			}
+			continue
+		}
+		var loaded []customSD
+		if err := json.Unmarshal(b, &loaded); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(loaded, groups) {
+			t.Errorf("%d groups: expected to load %v, got %v", tc.groups, groups, loaded)
+		}
+	}
+}
+
+func TestFileOutputChunksMaxBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prometheus-scw-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := newFileOutput(filepath.Join(dir, "scw.json"), 0, 0, 256)
+
+	b, err := json.Marshal(testGroups(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	matches, err := filepath.Glob(f.pattern())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) < 2 {
+		t.Fatalf("expected the targets to be split, got %v", matches)
+	}
+	var n int
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > 256 {
+			t.Errorf("expected %s to be at most 256 bytes, got %d", m, fi.Size())
+		}
+		n += len(readGroups(t, m))
+	}
+	if n != 6 {
+		t.Errorf("expected 6 groups in the chunk files, got %d", n)
+	}
+}
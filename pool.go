@@ -0,0 +1,61 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// The target groups are converted and serialized at every update of the
+// discovery manager, most of the time without any change. The buffers and
+// the label maps are reused across the updates to keep the memory usage flat
+// with large numbers of servers.
+var (
+	bufferPool = sync.Pool{
+		New: func() interface{} { return new(bytes.Buffer) },
+	}
+	labelsPool = sync.Pool{
+		New: func() interface{} { return make(map[string]string) },
+	}
+)
+
+func getBuffer() *bytes.Buffer {
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+func putBuffer(b *bytes.Buffer) {
+	bufferPool.Put(b)
+}
+
+func getLabels() map[string]string {
+	return labelsPool.Get().(map[string]string)
+}
+
+// releaseLabels returns the label maps of the groups to the pool. The groups
+// must not be used afterwards.
+func releaseLabels(groups map[string]*customSD) {
+	for _, g := range groups {
+		if g.Labels == nil {
+			continue
+		}
+		for k := range g.Labels {
+			delete(g.Labels, k)
+		}
+		labelsPool.Put(g.Labels)
+		g.Labels = nil
+	}
+}
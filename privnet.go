@@ -0,0 +1,303 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/scaleway/go-scaleway"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+var (
+	// vpcAPI is the base URL of the VPC API.
+	vpcAPI = "https://api.scaleway.com/vpc/v1"
+	// ipamAPI is the base URL of the IPAM API.
+	ipamAPI = "https://api.scaleway.com/ipam/v1"
+)
+
+// privateNetworkPageSize is the page size of the requests to the VPC API.
+const privateNetworkPageSize = 100
+
+// apiZones maps the zones of the compute API to the zones and regions of
+// the VPC and IPAM APIs.
+var apiZones = map[string]struct{ zone, region string }{
+	"par1": {"fr-par-1", "fr-par"},
+	"ams1": {"nl-ams-1", "nl-ams"},
+}
+
+// zoneAPI returns the URL of the compute API of the zone.
+func zoneAPI(zone string) string {
+	if zone == "ams1" {
+		return api.ComputeAPIAms1
+	}
+	return api.ComputeAPIPar1
+}
+
+// errNotFound is returned by computeGet when the resource doesn't exist.
+var errNotFound = errors.New("resource not found")
+
+// computeGet returns a resource of the compute API of the zone, for the
+// resources which aren't exposed by the API client. A missing resource
+// isn't counted as a failed request.
+func computeGet(client *api.ScalewayAPI, zone, resource string) ([]byte, error) {
+	now := time.Now()
+	resp, err := client.GetResponsePaginate(zoneAPI(zone), resource, url.Values{})
+	requestDuration.Observe(time.Since(now).Seconds())
+	if err != nil {
+		requestFailures.Inc()
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		requestFailures.Inc()
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusNotFound:
+		return nil, errNotFound
+	}
+	requestFailures.Inc()
+	return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, body)
+}
+
+// computeRequest decodes a resource of the compute API of the zone.
+func computeRequest(client *api.ScalewayAPI, zone, resource string, v interface{}) error {
+	body, err := computeGet(client, zone, resource)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// apiRequest decodes the response of a GET request to the Scaleway APIs
+// which aren't covered by the API client.
+func apiRequest(token, u string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", token)
+
+	now := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	requestDuration.Observe(time.Since(now).Seconds())
+	if err != nil {
+		requestFailures.Inc()
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		requestFailures.Inc()
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		requestFailures.Inc()
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, body)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// privateNIC is the attachment of a server to a Private Network.
+type privateNIC struct {
+	ID               string `json:"id"`
+	PrivateNetworkID string `json:"private_network_id"`
+}
+
+// cachedPrivateIP is the address of a server in the Private Network.
+type cachedPrivateIP struct {
+	modified string
+	ip       string
+}
+
+// privateNetworkSelector selects the address of the servers in a Private
+// Network, given by name or identifier. The Private Networks aren't exposed by
+// the API client: the private NICs of the servers are requested from the
+// compute API and their addresses from the IPAM API. The servers which aren't
+// attached to the Private Network are left out of the targets.
+type privateNetworkSelector struct {
+	network string
+	// client is the client of the current refresh.
+	client *api.ScalewayAPI
+	// ids are the identifiers of the Private Network per zone, resolved once
+	// per refresh.
+	ids map[string]string
+	// ips caches the addresses of the servers until their modification
+	// date changes. A stale address is used when the API fails.
+	ips map[string]cachedPrivateIP
+}
+
+func newPrivateNetworkSelector(network string) *privateNetworkSelector {
+	return &privateNetworkSelector{
+		network: network,
+		ips:     make(map[string]cachedPrivateIP),
+	}
+}
+
+// reset starts a new refresh with the given client.
+func (p *privateNetworkSelector) reset(client *api.ScalewayAPI) {
+	p.client = client
+	p.ids = make(map[string]string)
+}
+
+// networkID returns the identifier of the Private Network in the zone.
+func (p *privateNetworkSelector) networkID(zone string) (string, error) {
+	if id, ok := p.ids[zone]; ok {
+		return id, nil
+	}
+	z, ok := apiZones[zone]
+	if !ok {
+		return "", fmt.Errorf("unknown zone %q", zone)
+	}
+
+	var matches []string
+	for page, n := 1, 0; ; page++ {
+		q := url.Values{}
+		q.Set("page", strconv.Itoa(page))
+		q.Set("page_size", strconv.Itoa(privateNetworkPageSize))
+		var res struct {
+			PrivateNetworks []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"private_networks"`
+			TotalCount int `json:"total_count"`
+		}
+		if err := apiRequest(p.client.Token, fmt.Sprintf("%s/zones/%s/private-networks?%s", vpcAPI, z.zone, q.Encode()), &res); err != nil {
+			return "", err
+		}
+		for _, pn := range res.PrivateNetworks {
+			if pn.ID == p.network || pn.Name == p.network {
+				matches = append(matches, pn.ID)
+			}
+		}
+		n += len(res.PrivateNetworks)
+		if len(res.PrivateNetworks) == 0 || n >= res.TotalCount {
+			break
+		}
+	}
+	switch len(matches) {
+	case 0:
+		// The servers of the zone can't be attached to the Private Network.
+		p.ids[zone] = ""
+		return "", nil
+	case 1:
+		p.ids[zone] = matches[0]
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%d Private Networks named %q in %s", len(matches), p.network, z.zone)
+}
+
+// notAttachedError is returned for the servers without address in the Private Network.
+type notAttachedError string
+
+func (e notAttachedError) Error() string {
+	return string(e)
+}
+
+func isNotAttached(err error) bool {
+	_, ok := err.(notAttachedError)
+	return ok
+}
+
+// privateIP returns the address of the server in the Private Network.
+func (p *privateNetworkSelector) privateIP(srv *types.ScalewayServer) (string, error) {
+	id, err := p.networkID(srv.Location.ZoneID)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return "", notAttachedError(fmt.Sprintf("no Private Network %q in the zone %s", p.network, srv.Location.ZoneID))
+	}
+
+	var nics struct {
+		PrivateNICs []privateNIC `json:"private_nics"`
+	}
+	if err := computeRequest(p.client, srv.Location.ZoneID, "servers/"+srv.Identifier+"/private_nics", &nics); err != nil {
+		return "", err
+	}
+	var nic *privateNIC
+	for i := range nics.PrivateNICs {
+		if nics.PrivateNICs[i].PrivateNetworkID == id {
+			nic = &nics.PrivateNICs[i]
+			break
+		}
+	}
+	if nic == nil {
+		return "", notAttachedError(fmt.Sprintf("server not attached to the Private Network %q", p.network))
+	}
+
+	q := url.Values{}
+	q.Set("resource_id", nic.ID)
+	var res struct {
+		IPs []struct {
+			Address string `json:"address"`
+			IsIPv6  bool   `json:"is_ipv6"`
+		} `json:"ips"`
+	}
+	if err := apiRequest(p.client.Token, fmt.Sprintf("%s/regions/%s/ips?%s", ipamAPI, apiZones[srv.Location.ZoneID].region, q.Encode()), &res); err != nil {
+		return "", err
+	}
+	var ip string
+	for _, a := range res.IPs {
+		if ip == "" || (!a.IsIPv6 && strings.Contains(ip, ":")) {
+			// The addresses are in the CIDR notation.
+			ip = strings.SplitN(a.Address, "/", 2)[0]
+		}
+	}
+	if ip == "" {
+		return "", notAttachedError(fmt.Sprintf("no address in the Private Network %q", p.network))
+	}
+	return ip, nil
+}
+
+// Address implements the AddressSelector interface.
+func (p *privateNetworkSelector) Address(srv *types.ScalewayServer, port int) (string, error) {
+	c, ok := p.ips[srv.Identifier]
+	if !ok || c.modified != srv.ModificationDate || srv.ModificationDate == "" {
+		ip, err := p.privateIP(srv)
+		switch {
+		case err == nil:
+			c = cachedPrivateIP{modified: srv.ModificationDate, ip: ip}
+			p.ips[srv.Identifier] = c
+		case !isNotAttached(err) && ok:
+			// Keep the previous address until the API answers again.
+		default:
+			delete(p.ips, srv.Identifier)
+			return "", err
+		}
+	}
+	return net.JoinHostPort(c.ip, strconv.Itoa(port)), nil
+}
+
+// prune removes the addresses of the servers which aren't returned by the API anymore.
+func (p *privateNetworkSelector) prune(current map[string]struct{}) {
+	for id := range p.ips {
+		if _, ok := current[id]; !ok {
+			delete(p.ips, id)
+		}
+	}
+}
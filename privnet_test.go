@@ -0,0 +1,74 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/scaleway/prometheus-scw-sd/scwtest"
+)
+
+func TestPrivateNetworkSelector(t *testing.T) {
+	s := scwtest.NewServer(testToken)
+	defer s.Close()
+	defer s.Use()()
+	vpcAPI, ipamAPI = s.VPCURL(), s.IPAMURL()
+	web := testServer("web-1", "par1", "running", "10.0.0.1")
+	db := testServer("db-1", "par1", "running", "10.0.0.3")
+	ams := testServer("web-2", "ams1", "running", "10.0.0.2")
+	s.SetServers(web, db, ams)
+	s.AddPrivateNetwork("fr-par-1", scwtest.PrivateNetwork{ID: "pn-1", Name: "backend"})
+	s.AttachPrivateNetwork("web-1", "pn-1", "172.16.0.1/22")
+
+	client, err := newAPIClient(testToken, &scwLogger{log.NewNopLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newPrivateNetworkSelector("pn-1")
+	p.reset(client)
+
+	if addr, err := p.Address(&web, 9100); err != nil || addr != "172.16.0.1:9100" {
+		t.Fatalf("expected 172.16.0.1:9100, got %q (err: %v)", addr, err)
+	}
+	// The address is cached until the server is modified.
+	p.reset(client)
+	if _, err := p.Address(&web, 9100); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Requests(scwtest.PrivateNICs); n != 1 {
+		t.Errorf("expected 1 request of the private NICs, got %d", n)
+	}
+
+	// The previous address is kept when the API fails.
+	web.ModificationDate = "2018-05-02T10:00:00Z"
+	s.FailNext(scwtest.IPs, http.StatusInternalServerError, 1)
+	if addr, err := p.Address(&web, 9100); err != nil || addr != "172.16.0.1:9100" {
+		t.Errorf("expected the stale address 172.16.0.1:9100, got %q (err: %v)", addr, err)
+	}
+
+	if _, err := p.Address(&db, 80); !isNotAttached(err) {
+		t.Errorf("expected the server not to be attached, got %v", err)
+	}
+	if _, err := p.Address(&ams, 80); !isNotAttached(err) {
+		t.Errorf("expected the zone to have no Private Network, got %v", err)
+	}
+
+	// The addresses of the removed servers are forgotten.
+	p.prune(map[string]struct{}{})
+	if len(p.ips) != 0 {
+		t.Errorf("expected no cached address, got %d", len(p.ips))
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+// probeConcurrency is the maximum number of concurrent probes.
+const probeConcurrency = 32
+
+// wellKnownExporters maps the default ports of the common exporters to their names.
+var wellKnownExporters = map[int]string{
+	9100: "node",
+	9101: "haproxy",
+	9104: "mysqld",
+	9113: "nginx",
+	9115: "blackbox",
+	9117: "apache",
+	9121: "redis",
+	9182: "windows",
+	9187: "postgres",
+	9216: "mongodb",
+	9256: "process",
+	9419: "rabbitmq",
+}
+
+// exporterName returns the name of the exporter listening on the given port.
+func exporterName(port int) string {
+	if name, ok := wellKnownExporters[port]; ok {
+		return name
+	}
+	return strconv.Itoa(port)
+}
+
+// prober detects the exporters listening on the servers by opening a TCP
+// connection to a list of ports.
+type prober struct {
+	ports   []int
+	timeout time.Duration
+}
+
+// newProber returns a prober for the given ports or nil if the list is empty.
+func newProber(ports []int, timeout time.Duration) *prober {
+	if len(ports) == 0 {
+		return nil
+	}
+	return &prober{ports: ports, timeout: timeout}
+}
+
+// addresses returns the address of the server for each probed port.
+func (p *prober) addresses(srv *types.ScalewayServer, sel AddressSelector) map[int]string {
+	addrs := make(map[int]string, len(p.ports))
+	for _, port := range p.ports {
+		addr, err := sel.Address(srv, port)
+		if err != nil {
+			continue
+		}
+		addrs[port] = addr
+	}
+	return addrs
+}
+
+// probeAll returns the addresses accepting a TCP connection.
+func (p *prober) probeAll(addrs []string) map[string]struct{} {
+	var (
+		mtx sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, probeConcurrency)
+		res = make(map[string]struct{})
+	)
+	for _, addr := range addrs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(addr string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			conn, err := net.DialTimeout("tcp", addr, p.timeout)
+			if err != nil {
+				return
+			}
+			conn.Close()
+			mtx.Lock()
+			res[addr] = struct{}{}
+			mtx.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	return res
+}
+
+// expand returns one target group per responding port of the server,
+// derived from the server's target group.
+func (p *prober) expand(tg *targetgroup.Group, addrs map[int]string, open map[string]struct{}) []*targetgroup.Group {
+	var tgs []*targetgroup.Group
+	// Iterate over the configured ports to keep the order stable.
+	for _, port := range p.ports {
+		addr, ok := addrs[port]
+		if !ok {
+			continue
+		}
+		if _, ok := open[addr]; !ok {
+			continue
+		}
+		labels := tg.Labels.Clone()
+		labels[model.AddressLabel] = model.LabelValue(addr)
+		labels[model.LabelName(detectedExporterLabel)] = model.LabelValue(exporterName(port))
+		tgs = append(tgs, &targetgroup.Group{
+			Source:  fmt.Sprintf("%s/%d", tg.Source, port),
+			Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(addr)}},
+			Labels:  labels,
+		})
+	}
+	return tgs
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rateLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "prometheus_scaleway_sd_api_rate_limit",
+			Help: "Maximum number of requests allowed by the Scaleway API in the current window.",
+		},
+		[]string{"host"},
+	)
+	rateLimitRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "prometheus_scaleway_sd_api_rate_limit_remaining",
+			Help: "Number of requests remaining before being rate-limited by the Scaleway API.",
+		},
+		[]string{"host"},
+	)
+	rateLimitReset = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "prometheus_scaleway_sd_api_rate_limit_reset_timestamp_seconds",
+			Help: "Time at which the rate limit window of the Scaleway API resets.",
+		},
+		[]string{"host"},
+	)
+	rateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "prometheus_scaleway_sd_api_rate_limited_requests_total",
+			Help: "Total number of requests rejected by the Scaleway API because of the rate limit.",
+		},
+		[]string{"host"},
+	)
+)
+
+func init() {
+	reg.MustRegister(rateLimit)
+	reg.MustRegister(rateLimitRemaining)
+	reg.MustRegister(rateLimitReset)
+	reg.MustRegister(rateLimited)
+}
+
+// rateLimitTransport records the rate-limit headers returned by the API.
+type rateLimitTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	host := req.URL.Host
+	if resp.StatusCode == http.StatusTooManyRequests {
+		rateLimited.WithLabelValues(host).Inc()
+	}
+	if v, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Limit"), 64); err == nil {
+		rateLimit.WithLabelValues(host).Set(v)
+	}
+	if v, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Remaining"), 64); err == nil {
+		rateLimitRemaining.WithLabelValues(host).Set(v)
+	}
+	if v, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset"), 64); err == nil {
+		// The reset is either a Unix timestamp or a number of seconds.
+		if v < 1e9 {
+			v += float64(time.Now().Unix())
+		}
+		rateLimitReset.WithLabelValues(host).Set(v)
+	}
+	return resp, nil
+}
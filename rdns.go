@@ -0,0 +1,161 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// rdnsCacheTTL is the duration during which a reverse DNS lookup is cached.
+const rdnsCacheTTL = time.Hour
+
+// rdnsWorkers bounds the number of concurrent reverse DNS lookups.
+const rdnsWorkers = 16
+
+type rdnsEntry struct {
+	name    string
+	expires time.Time
+}
+
+// reverseResolver looks up the names of the addresses. The results, including
+// the failed lookups, are cached to avoid querying the DNS at every refresh.
+type reverseResolver struct {
+	timeout time.Duration
+	// lookupAddr is net.DefaultResolver.LookupAddr, replaced in the tests.
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+
+	mtx   sync.Mutex
+	cache map[string]rdnsEntry
+	// batch is closed when the running lookups complete (nil if none is running).
+	batch chan struct{}
+}
+
+func newReverseResolver(timeout time.Duration) *reverseResolver {
+	return &reverseResolver{
+		timeout:    timeout,
+		lookupAddr: net.DefaultResolver.LookupAddr,
+		cache:      make(map[string]rdnsEntry),
+	}
+}
+
+// resolve looks up the addresses missing from the cache, at most rdnsWorkers
+// at a time. It waits for the lookups up to the timeout and the remaining
+// ones complete in the background: no lookup is started until they are done.
+func (r *reverseResolver) resolve(addrs []string) {
+	r.mtx.Lock()
+	if r.batch != nil {
+		r.mtx.Unlock()
+		return
+	}
+	now := time.Now()
+	var missing []string
+	queued := make(map[string]struct{})
+	for _, addr := range addrs {
+		if _, ok := queued[addr]; ok {
+			continue
+		}
+		if e, ok := r.cache[addr]; ok && now.Before(e.expires) {
+			continue
+		}
+		queued[addr] = struct{}{}
+		missing = append(missing, addr)
+	}
+	if len(missing) == 0 {
+		r.mtx.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	r.batch = done
+	r.mtx.Unlock()
+
+	go func() {
+		ch := make(chan string)
+		var wg sync.WaitGroup
+		for i := 0; i < rdnsWorkers && i < len(missing); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for addr := range ch {
+					r.lookup(addr)
+				}
+			}()
+		}
+		for _, addr := range missing {
+			ch <- addr
+		}
+		close(ch)
+		wg.Wait()
+
+		r.mtx.Lock()
+		r.batch = nil
+		r.mtx.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(r.timeout):
+	}
+}
+
+// lookup caches the first name of the address or an empty string.
+func (r *reverseResolver) lookup(addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	var name string
+	names, err := r.lookupAddr(ctx, addr)
+	if err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.cache[addr] = rdnsEntry{name: name, expires: time.Now().Add(rdnsCacheTTL)}
+}
+
+// label returns the target group with the name of its address. The given
+// group isn't modified since it may be cached.
+func (r *reverseResolver) label(tg *targetgroup.Group) *targetgroup.Group {
+	host, _, _ := net.SplitHostPort(string(tg.Labels[model.AddressLabel]))
+	r.mtx.Lock()
+	name := r.cache[host].name
+	r.mtx.Unlock()
+
+	labels := tg.Labels.Clone()
+	labels[model.LabelName(reverseDNSLabel)] = model.LabelValue(name)
+	return &targetgroup.Group{
+		Source:  tg.Source,
+		Targets: tg.Targets,
+		Labels:  labels,
+	}
+}
+
+// expire removes the expired entries from the cache.
+func (r *reverseResolver) expire() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	now := time.Now()
+	for addr, e := range r.cache {
+		if now.After(e.expires) {
+			delete(r.cache, addr)
+		}
+	}
+}
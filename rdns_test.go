@@ -0,0 +1,98 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestReverseResolver(t *testing.T) {
+	var (
+		mtx               sync.Mutex
+		inFlight, maxSeen int
+		lookups           int
+	)
+	release := make(chan struct{})
+	r := newReverseResolver(50 * time.Millisecond)
+	r.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		mtx.Lock()
+		inFlight++
+		lookups++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		mtx.Unlock()
+		if addr == "10.0.0.99" {
+			// A slow DNS server.
+			<-release
+		}
+		mtx.Lock()
+		inFlight--
+		mtx.Unlock()
+		return []string{"host-" + addr + ".example.com."}, nil
+	}
+
+	var addrs []string
+	for i := 0; i < rdnsWorkers*3; i++ {
+		addrs = append(addrs, fmt.Sprintf("10.0.0.%d", i))
+	}
+	addrs = append(addrs, "10.0.0.99", "10.0.0.1")
+	start := time.Now()
+	r.resolve(addrs)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected resolve to return after the timeout, took %v", d)
+	}
+	tg := r.label(testGroup("web-1", "10.0.0.1:80", model.LabelSet{model.AddressLabel: "10.0.0.1:80"}))
+	if got := tg.Labels[model.LabelName(reverseDNSLabel)]; got != "host-10.0.0.1.example.com" {
+		t.Errorf("expected the name of 10.0.0.1, got %q", got)
+	}
+	tg = r.label(testGroup("slow-1", "10.0.0.99:80", model.LabelSet{model.AddressLabel: "10.0.0.99:80"}))
+	if got := tg.Labels[model.LabelName(reverseDNSLabel)]; got != "" {
+		t.Errorf("expected no name for the pending lookup, got %q", got)
+	}
+
+	// No lookup is started while the previous ones are running.
+	r.resolve([]string{"10.0.1.1"})
+	close(release)
+	for i := 0; ; i++ {
+		r.mtx.Lock()
+		running := r.batch != nil
+		r.mtx.Unlock()
+		if !running {
+			break
+		}
+		if i > 100 {
+			t.Fatal("expected the lookups to complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if lookups != len(addrs)-1 {
+		t.Errorf("expected %d lookups, got %d", len(addrs)-1, lookups)
+	}
+	if maxSeen > rdnsWorkers {
+		t.Errorf("expected at most %d concurrent lookups, got %d", rdnsWorkers, maxSeen)
+	}
+	tg = r.label(testGroup("slow-1", "10.0.0.99:80", model.LabelSet{model.AddressLabel: "10.0.0.99:80"}))
+	if got := tg.Labels[model.LabelName(reverseDNSLabel)]; got != "host-10.0.0.99.example.com" {
+		t.Errorf("expected the name of 10.0.0.99 once resolved, got %q", got)
+	}
+}
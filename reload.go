@@ -0,0 +1,177 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	fsnotify "gopkg.in/fsnotify/fsnotify.v1"
+)
+
+// reloader notifies the discoverers that the token file or the filter files
+// must be read again, either on SIGHUP or when the files change.
+type reloader struct {
+	// paths are the watched files (not watched if empty).
+	paths       map[string]struct{}
+	subscribers []chan struct{}
+	logger      log.Logger
+}
+
+// newReloader returns a reloader watching the given files if watch is true.
+// The empty paths are ignored.
+func newReloader(watch bool, paths []string, logger log.Logger) *reloader {
+	r := &reloader{
+		paths:  make(map[string]struct{}),
+		logger: log.With(logger, "component", "reloader"),
+	}
+	if watch {
+		for _, p := range paths {
+			if p != "" {
+				r.paths[filepath.Clean(p)] = struct{}{}
+			}
+		}
+	}
+	return r
+}
+
+// subscribe returns a channel receiving a value when a reload is requested.
+// It must be called before Run.
+func (r *reloader) subscribe() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	r.subscribers = append(r.subscribers, ch)
+	return ch
+}
+
+func (r *reloader) notify(reason string) {
+	level.Info(r.logger).Log("msg", "reloading", "reason", reason)
+	for _, ch := range r.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watcher returns a watcher of the directories of the files. The directories
+// are watched rather than the files so that the files replaced by a rename
+// (eg Kubernetes secrets) are still watched.
+func (r *reloader) watcher() (*fsnotify.Watcher, error) {
+	if len(r.paths) == 0 {
+		return nil, nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]struct{})
+	for p := range r.paths {
+		dirs[filepath.Dir(p)] = struct{}{}
+	}
+	for d := range dirs {
+		if err := w.Add(d); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Run waits for the reload requests until the context is done.
+func (r *reloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var events <-chan fsnotify.Event
+	w, err := r.watcher()
+	if err != nil {
+		level.Error(r.logger).Log("msg", "failed to watch the files, only SIGHUP triggers a reload", "err", err)
+	} else if w != nil {
+		defer w.Close()
+		events = w.Events
+		go func() {
+			for err := range w.Errors {
+				level.Warn(r.logger).Log("msg", "file watch error", "err", err)
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-hup:
+			r.notify("SIGHUP")
+		case e := <-events:
+			name := filepath.Clean(e.Name)
+			// Kubernetes updates the mounted secrets by swapping the "..data" symlink.
+			if _, ok := r.paths[name]; ok || strings.HasPrefix(filepath.Base(name), "..") {
+				r.notify("file changed: " + name)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload reads the token and the filter files again. The current settings
+// are kept if any of them is invalid.
+func (d *scwDiscoverer) reload() {
+	filter, err := newServerFilter(organizations, filterTags, tagsMatch, excludeTags, excludeMatch, excludeIDs, excludeIDsFile)
+	if err != nil {
+		level.Error(d.logger).Log("msg", "failed to reload the filter", "err", err)
+		return
+	}
+	if !d.updateToken() {
+		return
+	}
+	d.filter = filter
+}
+
+// updateToken rebuilds the API client when the credentials provider returns
+// a new token. It returns false and keeps the current client if the token
+// can't be read or is invalid.
+func (d *scwDiscoverer) updateToken() bool {
+	token, err := readToken()
+	if err != nil {
+		level.Error(d.logger).Log("msg", "failed to reload the token", "err", err)
+		return false
+	}
+	if token == d.client.Token {
+		return true
+	}
+	client, err := newAPIClient(token, &scwLogger{Logger: d.logger})
+	if err != nil {
+		level.Error(d.logger).Log("msg", "failed to reload the token", "err", err)
+		return false
+	}
+	d.client = client
+	level.Info(d.logger).Log("msg", "token reloaded")
+	return true
+}
+
+// reload reads the token again.
+func (d *iotDiscoverer) reload() {
+	token, err := readToken()
+	if err != nil {
+		level.Error(d.logger).Log("msg", "failed to reload the token", "err", err)
+		return
+	}
+	d.token = token
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var refreshWait = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "prometheus_scaleway_sd_refresh_wait_seconds",
+		Help:    "Histogram of the time spent by the refreshes waiting for another product's refresh.",
+		Buckets: []float64{0.001, 0.01, 0.1, 0.5, 1.0, 2.0, 5.0, 10.0},
+	},
+	[]string{"product"},
+)
+
+func init() {
+	reg.MustRegister(refreshWait)
+}
+
+// apiScheduler coordinates the refreshes of the products discovered by the
+// process so that no more than a given number of them query the API at the
+// same time, whatever their refresh intervals.
+type apiScheduler struct {
+	sem chan struct{}
+}
+
+func newAPIScheduler(concurrency int) *apiScheduler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &apiScheduler{sem: make(chan struct{}, concurrency)}
+}
+
+// do runs the refresh of the product once a slot is available. It returns
+// false without running the refresh if the context is done first. A nil
+// scheduler runs the refresh immediately.
+func (s *apiScheduler) do(ctx context.Context, product string, refresh func()) bool {
+	if s == nil {
+		refresh()
+		return true
+	}
+	start := time.Now()
+	select {
+	case s.sem <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	refreshWait.WithLabelValues(product).Observe(time.Since(start).Seconds())
+	defer func() { <-s.sem }()
+	refresh()
+	return true
+}
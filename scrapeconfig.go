@@ -0,0 +1,64 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"text/template"
+)
+
+var scrapeConfigTmpl = template.Must(template.New("scrape_config").Parse(`- job_name: {{ printf "%q" .JobName }}
+{{ if .URL }}
+  http_sd_configs:
+  - url: {{ printf "%q" .URL }}
+{{ else }}
+  file_sd_configs:
+  - files: [ {{ printf "%q" .File }} ]
+{{ end }}
+  # The relabeling does the following:
+  # - overwrite the instance label with the server's name.
+  # - save the zone label (par1/ams1).
+  # - save the commercial type label (eg START1-XS).
+  # - strip leading and trailing commas from the tags label.
+  relabel_configs:
+  - source_labels: [__meta_scaleway_name]
+    target_label: instance
+  - source_labels: [__meta_scaleway_zone_id]
+    target_label: zone
+  - source_labels: [__meta_scaleway_commercial_type]
+    target_label: type
+  - source_labels: [__meta_scaleway_tags]
+    regex: ",(.+),"
+    target_label: tags
+`))
+
+// genScrapeConfig prints a Prometheus scrape configuration reading the targets
+// written by the service discovery, either from the files (a path or a glob)
+// or from the http_sd URL if not empty.
+func genScrapeConfig(w io.Writer, jobName, files, url string) error {
+	abs, err := filepath.Abs(files)
+	if err != nil {
+		return err
+	}
+	return scrapeConfigTmpl.Execute(w, struct {
+		JobName string
+		File    string
+		URL     string
+	}{
+		JobName: jobName,
+		File:    abs,
+		URL:     url,
+	})
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenScrapeConfigSplit(t *testing.T) {
+	for _, tc := range []struct {
+		maxTargets int
+		want       string
+	}{
+		{0, `- files: [ "/etc/prometheus/scw.json" ]`},
+		{100, `- files: [ "/etc/prometheus/scw-*.json" ]`},
+	} {
+		var buf bytes.Buffer
+		files := newFileOutput("/etc/prometheus/scw.json", 0, tc.maxTargets, 0).pattern()
+		if err := genScrapeConfig(&buf, "scaleway", files, ""); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), tc.want) {
+			t.Errorf("expected %s in the configuration, got:\n%s", tc.want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,586 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scwtest provides a fake of the Scaleway API endpoints used by the
+// service discovery, to test it end-to-end and to simulate a fleet locally.
+//
+// The fake serves the account API (tokens and organizations), the compute
+// API of every zone (servers and their maintenances, user_data, security
+// groups and private NICs), the IoT Hub API and the VPC and IPAM APIs
+// (Private Networks and their addresses) from a single httptest server. The
+// resources of a server are only served by the compute API of its zone. The
+// list endpoints are paginated like the Scaleway API, and errors and rate
+// limits can be injected.
+package scwtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/scaleway/go-scaleway"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+// Endpoint identifies a group of API routes for the injected errors and the
+// request counters.
+type Endpoint string
+
+// The endpoints served by the fake API.
+const (
+	Tokens          Endpoint = "tokens"
+	Organizations   Endpoint = "organizations"
+	Servers         Endpoint = "servers"
+	Userdata        Endpoint = "user_data"
+	SecurityGroups  Endpoint = "security_groups"
+	Hubs            Endpoint = "hubs"
+	PrivateNICs     Endpoint = "private_nics"
+	PrivateNetworks Endpoint = "private_networks"
+	IPs             Endpoint = "ips"
+)
+
+// PerPage is the page size of the compute and account APIs.
+const PerPage = 50
+
+// Hub is an IoT Hub served by the fake IoT Hub API.
+type Hub struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Status       string `json:"status"`
+	ProductPlan  string `json:"product_plan"`
+	Enabled      bool   `json:"enabled"`
+	Endpoint     string `json:"endpoint"`
+	Region       string `json:"region"`
+	Organization string `json:"organization_id"`
+}
+
+// PrivateNetwork is a Private Network served by the fake VPC API.
+type PrivateNetwork struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Zone string `json:"zone"`
+}
+
+type privateNIC struct {
+	ID               string `json:"id"`
+	ServerID         string `json:"server_id"`
+	PrivateNetworkID string `json:"private_network_id"`
+}
+
+type ip struct {
+	Address string `json:"address"`
+	IsIPv6  bool   `json:"is_ipv6"`
+}
+
+// Maintenance is a maintenance scheduled on a server.
+type Maintenance struct {
+	Reason    string `json:"reason"`
+	StartDate string `json:"start_date"`
+}
+
+type securityGroup struct {
+	policy string
+	rules  []types.ScalewaySecurityGroupRule
+}
+
+// Server is a fake Scaleway API. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+	// Token is the only token accepted by the fake API.
+	Token string
+
+	mtx      sync.Mutex
+	servers  []types.ScalewayServer
+	orgs     []types.ScalewayOrganizationDefinition
+	userdata map[string]map[string]string
+	maints   map[string][]Maintenance
+	groups   map[string]securityGroup
+	hubs     map[string][]Hub
+	networks map[string][]PrivateNetwork
+	nics     map[string][]privateNIC
+	ips      map[string][]ip
+	// failures are the status codes returned by the next requests per endpoint.
+	failures map[Endpoint][]int
+	requests map[Endpoint]int
+
+	// The rate limit is disabled if limit is 0.
+	limit       int
+	window      time.Duration
+	windowStart time.Time
+	windowCount int
+}
+
+// NewServer starts a fake API accepting the given token. It must be closed
+// when done.
+func NewServer(token string) *Server {
+	s := &Server{
+		Token:    token,
+		userdata: make(map[string]map[string]string),
+		maints:   make(map[string][]Maintenance),
+		groups:   make(map[string]securityGroup),
+		hubs:     make(map[string][]Hub),
+		networks: make(map[string][]PrivateNetwork),
+		nics:     make(map[string][]privateNIC),
+		ips:      make(map[string][]ip),
+		failures: make(map[Endpoint][]int),
+		requests: make(map[Endpoint]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// AccountURL returns the URL of the account API.
+func (s *Server) AccountURL() string {
+	return s.URL + "/account"
+}
+
+// ComputeURL returns the URL of the compute API of the zone (eg par1).
+func (s *Server) ComputeURL(zone string) string {
+	return s.URL + "/compute/" + zone
+}
+
+// IoTURL returns the base URL of the IoT Hub API.
+func (s *Server) IoTURL() string {
+	return s.URL + "/iot/v1"
+}
+
+// VPCURL returns the base URL of the VPC API.
+func (s *Server) VPCURL() string {
+	return s.URL + "/vpc/v1"
+}
+
+// IPAMURL returns the base URL of the IPAM API.
+func (s *Server) IPAMURL() string {
+	return s.URL + "/ipam/v1"
+}
+
+// Use points the Scaleway API client at the fake API and returns a function
+// restoring the previous URLs.
+func (s *Server) Use() func() {
+	account, par1, ams1 := api.AccountAPI, api.ComputeAPIPar1, api.ComputeAPIAms1
+	api.AccountAPI = s.AccountURL()
+	api.ComputeAPIPar1 = s.ComputeURL("par1")
+	api.ComputeAPIAms1 = s.ComputeURL("ams1")
+	return func() {
+		api.AccountAPI, api.ComputeAPIPar1, api.ComputeAPIAms1 = account, par1, ams1
+	}
+}
+
+// SetServers replaces the servers of the fleet. The servers without zone are
+// in par1.
+func (s *Server) SetServers(srvs ...types.ScalewayServer) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.servers = append([]types.ScalewayServer(nil), srvs...)
+}
+
+// AddServer adds a server to the fleet or replaces the server with the same identifier.
+func (s *Server) AddServer(srv types.ScalewayServer) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for i := range s.servers {
+		if s.servers[i].Identifier == srv.Identifier {
+			s.servers[i] = srv
+			return
+		}
+	}
+	s.servers = append(s.servers, srv)
+}
+
+// RemoveServer removes a server from the fleet.
+func (s *Server) RemoveServer(id string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for i := range s.servers {
+		if s.servers[i].Identifier == id {
+			s.servers = append(s.servers[:i], s.servers[i+1:]...)
+			return
+		}
+	}
+}
+
+// AddOrganization adds an organization accessible with the token.
+func (s *Server) AddOrganization(id, name string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.orgs = append(s.orgs, types.ScalewayOrganizationDefinition{ID: id, Name: name})
+}
+
+// SetUserdata sets a user_data key of a server.
+func (s *Server) SetUserdata(serverID, key, value string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.userdata[serverID] == nil {
+		s.userdata[serverID] = make(map[string]string)
+	}
+	s.userdata[serverID][key] = value
+}
+
+// AddMaintenance schedules a maintenance on a server.
+func (s *Server) AddMaintenance(serverID string, m Maintenance) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.maints[serverID] = append(s.maints[serverID], m)
+}
+
+// SetSecurityGroup creates or replaces a security group with the inbound
+// default policy (accept or drop) and the rules. The group is served in the
+// zones of the servers using it.
+func (s *Server) SetSecurityGroup(id, inboundPolicy string, rules ...types.ScalewaySecurityGroupRule) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.groups[id] = securityGroup{policy: inboundPolicy, rules: rules}
+}
+
+// AddHub adds an IoT Hub to a region.
+func (s *Server) AddHub(region string, hub Hub) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if hub.Region == "" {
+		hub.Region = region
+	}
+	s.hubs[region] = append(s.hubs[region], hub)
+}
+
+// AddPrivateNetwork adds a Private Network to a zone (eg fr-par-1).
+func (s *Server) AddPrivateNetwork(zone string, pn PrivateNetwork) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if pn.Zone == "" {
+		pn.Zone = zone
+	}
+	s.networks[zone] = append(s.networks[zone], pn)
+}
+
+// AttachPrivateNetwork attaches a server to a Private Network with the
+// addresses (IPv4 or IPv6 in the CIDR notation).
+func (s *Server) AttachPrivateNetwork(serverID, networkID string, addrs ...string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	nic := privateNIC{
+		ID:               fmt.Sprintf("nic-%s-%s", serverID, networkID),
+		ServerID:         serverID,
+		PrivateNetworkID: networkID,
+	}
+	s.nics[serverID] = append(s.nics[serverID], nic)
+	for _, a := range addrs {
+		s.ips[nic.ID] = append(s.ips[nic.ID], ip{Address: a, IsIPv6: strings.Contains(a, ":")})
+	}
+}
+
+// FailNext makes the next n requests to the endpoint fail with the status code.
+// The HEAD requests used for the pagination aren't failed.
+func (s *Server) FailNext(e Endpoint, status, n int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures[e] = append(s.failures[e], status)
+	}
+}
+
+// SetRateLimit rejects the requests beyond limit per window with a 429
+// status. The rate limit is disabled if limit is 0.
+func (s *Server) SetRateLimit(limit int, window time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.limit = limit
+	s.window = window
+	s.windowStart = time.Now()
+	s.windowCount = 0
+}
+
+// Requests returns the number of GET requests received by the endpoint.
+func (s *Server) Requests(e Endpoint) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.requests[e]
+}
+
+// apiError writes an error formatted like the Scaleway API's.
+func apiError(w http.ResponseWriter, status int, typ, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"type": typ, "message": msg})
+}
+
+// rateLimited sets the rate-limit headers and returns true if the request
+// exceeds the rate limit.
+func (s *Server) rateLimited(w http.ResponseWriter) bool {
+	if s.limit == 0 {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(s.windowStart) >= s.window {
+		s.windowStart = now
+		s.windowCount = 0
+	}
+	s.windowCount++
+	remaining := s.limit - s.windowCount
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(s.windowStart.Add(s.window).Unix(), 10))
+	return s.windowCount > s.limit
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.rateLimited(w) {
+		apiError(w, http.StatusTooManyRequests, "rate_limited", "Rate limit exceeded")
+		return
+	}
+	if r.Header.Get("X-Auth-Token") != s.Token {
+		apiError(w, http.StatusUnauthorized, "invalid_auth", "Authentication error")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "account" && parts[1] == "tokens":
+		s.serveList(w, r, Tokens, "tokens", []types.ScalewayTokenDefinition{{ID: s.Token}})
+	case len(parts) == 2 && parts[0] == "account" && parts[1] == "organizations":
+		s.serveList(w, r, Organizations, "organizations", s.orgs)
+	case len(parts) == 3 && parts[0] == "compute" && parts[2] == "servers":
+		s.serveList(w, r, Servers, "servers", s.withMaintenances(s.zoneServers(parts[1], r.URL.Query().Get("state"))))
+	case len(parts) == 6 && parts[0] == "compute" && parts[2] == "servers" && parts[4] == "user_data":
+		s.serveUserdata(w, r, parts[1], parts[3], parts[5])
+	case len(parts) == 4 && parts[0] == "compute" && parts[2] == "security_groups":
+		s.serveSecurityGroup(w, r, parts[1], parts[3], false)
+	case len(parts) == 5 && parts[0] == "compute" && parts[2] == "security_groups" && parts[4] == "rules":
+		s.serveSecurityGroup(w, r, parts[1], parts[3], true)
+	case len(parts) == 5 && parts[0] == "compute" && parts[2] == "servers" && parts[4] == "private_nics":
+		s.servePrivateNICs(w, r, parts[1], parts[3])
+	case len(parts) == 5 && parts[0] == "iot" && parts[2] == "regions" && parts[4] == "hubs":
+		s.servePage(w, r, Hubs, "hubs", s.hubs[parts[3]])
+	case len(parts) == 5 && parts[0] == "vpc" && parts[2] == "zones" && parts[4] == "private-networks":
+		s.servePage(w, r, PrivateNetworks, "private_networks", s.networks[parts[3]])
+	case len(parts) == 5 && parts[0] == "ipam" && parts[2] == "regions" && parts[4] == "ips":
+		s.servePage(w, r, IPs, "ips", s.ips[r.URL.Query().Get("resource_id")])
+	default:
+		apiError(w, http.StatusNotFound, "unknown_resource", "Unknown resource "+r.URL.Path)
+	}
+}
+
+// fail counts the request and returns true if an error has been injected.
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, e Endpoint) bool {
+	if r.Method == http.MethodHead {
+		return false
+	}
+	s.requests[e]++
+	if len(s.failures[e]) == 0 {
+		return false
+	}
+	status := s.failures[e][0]
+	s.failures[e] = s.failures[e][1:]
+	apiError(w, status, "injected_error", fmt.Sprintf("Injected error on %s", e))
+	return true
+}
+
+// zoneServers returns the servers of the zone, in the state if not empty.
+func (s *Server) zoneServers(zone, state string) []types.ScalewayServer {
+	srvs := []types.ScalewayServer{}
+	for _, srv := range s.servers {
+		z := srv.Location.ZoneID
+		if z == "" {
+			z = "par1"
+		}
+		if z != zone || (state != "" && srv.State != state) {
+			continue
+		}
+		srv.Location.ZoneID = z
+		srvs = append(srvs, srv)
+	}
+	sort.Slice(srvs, func(i, j int) bool { return srvs[i].Identifier < srvs[j].Identifier })
+	return srvs
+}
+
+// inZone returns true if the server is in the zone.
+func (s *Server) inZone(serverID, zone string) bool {
+	for _, srv := range s.zoneServers(zone, "") {
+		if srv.Identifier == serverID {
+			return true
+		}
+	}
+	return false
+}
+
+// groupInZone returns true if a server of the zone uses the security group,
+// as the security groups belong to a zone like their servers.
+func (s *Server) groupInZone(id, zone string) bool {
+	for _, srv := range s.zoneServers(zone, "") {
+		if srv.SecurityGroup.Identifier == id {
+			return true
+		}
+	}
+	return false
+}
+
+// withMaintenances adds the maintenances field, which the API client doesn't
+// know, to the servers.
+func (s *Server) withMaintenances(srvs []types.ScalewayServer) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0, len(srvs))
+	for _, srv := range srvs {
+		b, _ := json.Marshal(srv)
+		var m map[string]interface{}
+		json.Unmarshal(b, &m)
+		maints := append([]Maintenance{}, s.maints[srv.Identifier]...)
+		m["maintenances"] = maints
+		res = append(res, m)
+	}
+	return res
+}
+
+// serveList serves the items as a JSON object under the key, paginated
+// like the Scaleway API: the total count is returned in the X-Total-Count
+// header and the page and per_page parameters select a page.
+func (s *Server) serveList(w http.ResponseWriter, r *http.Request, e Endpoint, key string, items interface{}) {
+	if s.fail(w, r, e) {
+		return
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	var all []json.RawMessage
+	json.Unmarshal(b, &all)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(all)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	page := all
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+		perPage := PerPage
+		if n, err := strconv.Atoi(r.URL.Query().Get("per_page")); err == nil && n > 0 {
+			perPage = n
+		}
+		start, end := (p-1)*perPage, p*perPage
+		if start > len(all) {
+			start = len(all)
+		}
+		if end > len(all) {
+			end = len(all)
+		}
+		page = all[start:end]
+	}
+	if page == nil {
+		page = []json.RawMessage{}
+	}
+	json.NewEncoder(w).Encode(map[string][]json.RawMessage{key: page})
+}
+
+func (s *Server) serveUserdata(w http.ResponseWriter, r *http.Request, zone, serverID, key string) {
+	if s.fail(w, r, Userdata) {
+		return
+	}
+	if !s.inZone(serverID, zone) {
+		apiError(w, http.StatusNotFound, "unknown_resource", "Unknown server "+serverID)
+		return
+	}
+	v, ok := s.userdata[serverID][key]
+	if !ok {
+		apiError(w, http.StatusNotFound, "unknown_resource", "Unknown user_data "+key)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(v))
+}
+
+func (s *Server) serveSecurityGroup(w http.ResponseWriter, r *http.Request, zone, id string, rules bool) {
+	if s.fail(w, r, SecurityGroups) {
+		return
+	}
+	g, ok := s.groups[id]
+	if !ok || !s.groupInZone(id, zone) {
+		apiError(w, http.StatusNotFound, "unknown_resource", "Unknown security group "+id)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if rules {
+		rs := g.rules
+		if rs == nil {
+			rs = []types.ScalewaySecurityGroupRule{}
+		}
+		json.NewEncoder(w).Encode(types.ScalewayGetSecurityGroupRules{Rules: rs})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"security_group": map[string]string{
+			"id":                     id,
+			"inbound_default_policy": g.policy,
+		},
+	})
+}
+
+func (s *Server) servePrivateNICs(w http.ResponseWriter, r *http.Request, zone, serverID string) {
+	if s.fail(w, r, PrivateNICs) {
+		return
+	}
+	if !s.inZone(serverID, zone) {
+		apiError(w, http.StatusNotFound, "unknown_resource", "Unknown server "+serverID)
+		return
+	}
+	nics := append([]privateNIC{}, s.nics[serverID]...)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]privateNIC{"private_nics": nics})
+}
+
+// servePage serves the items as a JSON object under the key, paginated with
+// the page and page_size parameters and the total_count field like the
+// IoT Hub, VPC and IPAM APIs.
+func (s *Server) servePage(w http.ResponseWriter, r *http.Request, e Endpoint, key string, items interface{}) {
+	if s.fail(w, r, e) {
+		return
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	var all []json.RawMessage
+	json.Unmarshal(b, &all)
+
+	page := 1
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+		page = p
+	}
+	size := len(all)
+	if n, err := strconv.Atoi(r.URL.Query().Get("page_size")); err == nil && n > 0 {
+		size = n
+	}
+	start, end := (page-1)*size, page*size
+	if start > len(all) {
+		start = len(all)
+	}
+	if end > len(all) {
+		end = len(all)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		key:           append([]json.RawMessage{}, all[start:end]...),
+		"total_count": len(all),
+	})
+}
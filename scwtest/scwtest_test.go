@@ -0,0 +1,165 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scwtest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	api "github.com/scaleway/go-scaleway"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+func newClient(t *testing.T, token string) *api.ScalewayAPI {
+	client, err := api.NewScalewayAPI("", token, "scwtest", "par1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func server(id, zone, state string) types.ScalewayServer {
+	srv := types.ScalewayServer{Identifier: id, Name: id, State: state}
+	srv.Location.ZoneID = zone
+	return srv
+}
+
+func TestServers(t *testing.T) {
+	s := NewServer("token")
+	defer s.Close()
+	defer s.Use()()
+
+	// More servers than a page in par1.
+	var srvs []types.ScalewayServer
+	for i := 0; i < PerPage*2+10; i++ {
+		srvs = append(srvs, server(fmt.Sprintf("par1-%03d", i), "", "running"))
+	}
+	srvs = append(srvs,
+		server("ams1-1", "ams1", "running"),
+		server("ams1-2", "ams1", "stopped"),
+	)
+	s.SetServers(srvs...)
+
+	for _, tc := range []struct {
+		all  bool
+		want int
+	}{
+		{all: false, want: PerPage*2 + 11},
+		{all: true, want: PerPage*2 + 12},
+	} {
+		got, err := newClient(t, "token").GetServers(tc.all, 0)
+		if err != nil {
+			t.Fatalf("all=%v: %v", tc.all, err)
+		}
+		if len(*got) != tc.want {
+			t.Errorf("all=%v: expected %d servers, got %d", tc.all, tc.want, len(*got))
+		}
+		seen := make(map[string]struct{})
+		for _, srv := range *got {
+			if _, ok := seen[srv.Identifier]; ok {
+				t.Errorf("all=%v: duplicate server %s", tc.all, srv.Identifier)
+			}
+			seen[srv.Identifier] = struct{}{}
+		}
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	s := NewServer("token")
+	defer s.Close()
+	defer s.Use()()
+
+	if err := newClient(t, "token").CheckCredentials(); err != nil {
+		t.Fatalf("expected valid credentials, got %v", err)
+	}
+	if err := newClient(t, "other").CheckCredentials(); err == nil {
+		t.Fatal("expected invalid credentials")
+	}
+}
+
+func TestFailNext(t *testing.T) {
+	s := NewServer("token")
+	defer s.Close()
+	defer s.Use()()
+	s.AddOrganization("org-1", "acme")
+	s.FailNext(Organizations, http.StatusInternalServerError, 1)
+
+	client := newClient(t, "token")
+	if _, err := client.GetOrganization(); err == nil {
+		t.Fatal("expected an error")
+	}
+	orgs, err := client.GetOrganization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orgs.Organizations) != 1 || orgs.Organizations[0].Name != "acme" {
+		t.Errorf("unexpected organizations %+v", orgs.Organizations)
+	}
+	if n := s.Requests(Organizations); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := NewServer("token")
+	defer s.Close()
+	s.SetRateLimit(2, time.Minute)
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req, _ := http.NewRequest(http.MethodGet, s.AccountURL()+"/organizations", nil)
+		req.Header.Set("X-Auth-Token", "token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("request %d: expected status %d, got %d", i, want, resp.StatusCode)
+		}
+		if resp.Header.Get("X-RateLimit-Limit") != "2" {
+			t.Errorf("request %d: expected the rate-limit headers", i)
+		}
+	}
+}
+
+func TestUserdata(t *testing.T) {
+	s := NewServer("token")
+	defer s.Close()
+	defer s.Use()()
+	s.SetServers(server("srv-1", "par1", "running"))
+	s.SetUserdata("srv-1", "prometheus", `{"port":9100}`)
+
+	client := newClient(t, "token")
+	data, err := client.GetUserdata("srv-1", "prometheus", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(*data) != `{"port":9100}` {
+		t.Errorf("unexpected user_data %q", *data)
+	}
+	if _, err := client.GetUserdata("srv-1", "missing", false); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+
+	// The server isn't served by the compute API of the other zone.
+	ams1, err := api.NewScalewayAPI("", "token", "scwtest", "ams1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ams1.GetUserdata("srv-1", "prometheus", false); err == nil {
+		t.Error("expected an error for a server of another zone")
+	}
+}
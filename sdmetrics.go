@@ -0,0 +1,107 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The metrics below follow the naming of the Prometheus service discovery
+// metrics so that the existing dashboards and mixins work unmodified.
+var (
+	sdRefreshDuration = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name:       "prometheus_sd_refresh_duration_seconds",
+			Help:       "The duration of a refresh in seconds for the given SD mechanism.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		},
+		[]string{"mechanism"},
+	)
+	sdRefreshFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "prometheus_sd_refresh_failures_total",
+			Help: "Number of refresh failures for the given SD mechanism.",
+		},
+		[]string{"mechanism"},
+	)
+	sdReceivedUpdates = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "prometheus_sd_received_updates_total",
+			Help: "Total number of update events received from the SD providers.",
+		},
+		[]string{"name"},
+	)
+	sdUpdates = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "prometheus_sd_updates_total",
+			Help: "Total number of update events sent to the SD consumers.",
+		},
+		[]string{"name"},
+	)
+	sdDiscoveredTargets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "prometheus_sd_discovered_targets",
+			Help: "Current number of discovered targets.",
+		},
+		[]string{"name", "config"},
+	)
+	sdFailedConfigs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "prometheus_sd_failed_configs",
+			Help: "Current number of service discovery configurations that failed to load.",
+		},
+		[]string{"name"},
+	)
+	sdFileMtime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "prometheus_sd_file_mtime_seconds",
+			Help: "Timestamp (mtime) of the files written by the file output.",
+		},
+		[]string{"filename"},
+	)
+
+	// failedMechanisms holds the mechanisms whose last refresh failed.
+	failedMechanisms   = make(map[string]struct{})
+	failedMechanismsMu sync.Mutex
+)
+
+func init() {
+	reg.MustRegister(sdRefreshDuration)
+	reg.MustRegister(sdRefreshFailures)
+	reg.MustRegister(sdReceivedUpdates)
+	reg.MustRegister(sdUpdates)
+	reg.MustRegister(sdDiscoveredTargets)
+	reg.MustRegister(sdFailedConfigs)
+	reg.MustRegister(sdFileMtime)
+}
+
+// observeRefresh records the outcome of a refresh of the given mechanism
+// started at start.
+func observeRefresh(mechanism string, start time.Time, err error) {
+	sdRefreshDuration.WithLabelValues(mechanism).Observe(time.Since(start).Seconds())
+
+	failedMechanismsMu.Lock()
+	defer failedMechanismsMu.Unlock()
+	if err != nil {
+		sdRefreshFailures.WithLabelValues(mechanism).Inc()
+		failedMechanisms[mechanism] = struct{}{}
+	} else {
+		sdReceivedUpdates.WithLabelValues(adapterName).Inc()
+		delete(failedMechanisms, mechanism)
+	}
+	sdFailedConfigs.WithLabelValues(adapterName).Set(float64(len(failedMechanisms)))
+}
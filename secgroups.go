@@ -0,0 +1,172 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	api "github.com/scaleway/go-scaleway"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+var blockedTargets = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "prometheus_scaleway_sd_security_group_blocked_targets",
+		Help: "Number of targets dropped because their port isn't allowed inbound by the security group of their server.",
+	},
+)
+
+func init() {
+	reg.MustRegister(blockedTargets)
+}
+
+// securityGroup holds the inbound policy of a security group.
+type securityGroup struct {
+	// policy is the action applied when no rule matches.
+	policy string
+	rules  []types.ScalewaySecurityGroupRule
+}
+
+// allows returns true if the TCP port is allowed inbound. The IP ranges of
+// the accepting rules are ignored since the address of Prometheus is unknown,
+// so only the ports dropped for everyone are reported as blocked.
+func (g *securityGroup) allows(port int) bool {
+	for _, r := range g.rules {
+		if !strings.EqualFold(r.Direction, "inbound") {
+			continue
+		}
+		if p := strings.ToUpper(r.Protocol); p != "TCP" && p != "ANY" {
+			continue
+		}
+		if !rulePortMatches(r, port) {
+			continue
+		}
+		if strings.EqualFold(r.Action, "accept") {
+			return true
+		}
+		if r.IPRange == "0.0.0.0/0" {
+			return false
+		}
+	}
+	return !strings.EqualFold(g.policy, "drop")
+}
+
+// rulePortMatches returns true if the port is in the destination ports of the rule.
+func rulePortMatches(r types.ScalewaySecurityGroupRule, port int) bool {
+	if r.DestPortFrom == 0 {
+		// The rule applies to all the ports.
+		return true
+	}
+	to, err := strconv.Atoi(r.DestPortTo)
+	if err != nil || to == 0 {
+		return port == r.DestPortFrom
+	}
+	return port >= r.DestPortFrom && port <= to
+}
+
+// securityGroupChecker drops the targets whose port is blocked by the
+// security group of their server.
+type securityGroupChecker struct {
+	// client is the client of the current refresh.
+	client *api.ScalewayAPI
+	// groups caches the security groups during a refresh.
+	groups map[string]*securityGroup
+	// blocked are the sources of the targets dropped by the last refresh,
+	// to warn only once about every blocked target.
+	blocked map[string]struct{}
+	current map[string]struct{}
+	logger  log.Logger
+}
+
+func newSecurityGroupChecker(logger log.Logger) *securityGroupChecker {
+	return &securityGroupChecker{
+		blocked: make(map[string]struct{}),
+		logger:  log.With(logger, "component", "security-groups"),
+	}
+}
+
+// reset starts a new refresh with the given client.
+func (c *securityGroupChecker) reset(client *api.ScalewayAPI) {
+	c.client = client
+	c.groups = make(map[string]*securityGroup)
+	c.current = make(map[string]struct{})
+}
+
+// done ends the refresh.
+func (c *securityGroupChecker) done() {
+	c.blocked = c.current
+	blockedTargets.Set(float64(len(c.blocked)))
+}
+
+// get returns the security group in the given zone.
+func (c *securityGroupChecker) get(zone, id string) (*securityGroup, error) {
+	if g, ok := c.groups[id]; ok {
+		return g, nil
+	}
+
+	// The inbound default policy isn't exposed by the API client.
+	var sg struct {
+		SecurityGroup struct {
+			InboundDefaultPolicy string `json:"inbound_default_policy"`
+		} `json:"security_group"`
+	}
+	if err := computeRequest(c.client, zone, "security_groups/"+id, &sg); err != nil {
+		return nil, err
+	}
+	var rules types.ScalewayGetSecurityGroupRules
+	if err := computeRequest(c.client, zone, "security_groups/"+id+"/rules", &rules); err != nil {
+		return nil, err
+	}
+	sort.Slice(rules.Rules, func(i, j int) bool { return rules.Rules[i].Position < rules.Rules[j].Position })
+
+	g := &securityGroup{policy: sg.SecurityGroup.InboundDefaultPolicy, rules: rules.Rules}
+	c.groups[id] = g
+	return g, nil
+}
+
+// filter returns the target groups of the server whose port is allowed inbound.
+// The groups are kept when the security group can't be retrieved.
+func (c *securityGroupChecker) filter(srv *types.ScalewayServer, tgs []*targetgroup.Group) []*targetgroup.Group {
+	if srv.SecurityGroup.Identifier == "" {
+		return tgs
+	}
+	g, err := c.get(srv.Location.ZoneID, srv.SecurityGroup.Identifier)
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to get the security group", "server", srv.Identifier, "security_group", srv.SecurityGroup.Identifier, "err", err)
+		return tgs
+	}
+
+	allowed := make([]*targetgroup.Group, 0, len(tgs))
+	for _, tg := range tgs {
+		_, p, err := net.SplitHostPort(string(tg.Labels[model.AddressLabel]))
+		port, perr := strconv.Atoi(p)
+		if err != nil || perr != nil || g.allows(port) {
+			allowed = append(allowed, tg)
+			continue
+		}
+		if _, ok := c.blocked[tg.Source]; !ok {
+			level.Warn(c.logger).Log("msg", "target port blocked by the security group", "server", srv.Identifier, "name", srv.Name, "port", port, "security_group", srv.SecurityGroup.Name)
+		}
+		c.current[tg.Source] = struct{}{}
+	}
+	return allowed
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// staticTargets merges the targets of a file in the file_sd format (JSON or
+// YAML) into the discovered targets. The file is read at every write so that
+// it can be edited without restarting.
+type staticTargets struct {
+	path   string
+	groups []customSD
+	// collisions are the static targets which are also discovered.
+	collisions map[string]struct{}
+	logger     log.Logger
+}
+
+// newStaticTargets returns the static targets of the file or nil if the path is empty.
+func newStaticTargets(path string, logger log.Logger) (*staticTargets, error) {
+	if path == "" {
+		return nil, nil
+	}
+	s := &staticTargets{
+		path:       path,
+		collisions: make(map[string]struct{}),
+		logger:     log.With(logger, "component", "static-targets"),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *staticTargets) load() error {
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var groups []customSD
+	if err := yaml.Unmarshal(b, &groups); err != nil {
+		return err
+	}
+	s.groups = groups
+	return nil
+}
+
+// merge returns the discovered groups followed by the static groups. The
+// static targets having the address of a discovered target are dropped.
+func (s *staticTargets) merge(arr []customSD) []customSD {
+	if s == nil {
+		return arr
+	}
+	if err := s.load(); err != nil {
+		level.Error(s.logger).Log("msg", "failed to read the static targets, using the previous ones", "file", s.path, "err", err)
+	}
+
+	discovered := make(map[string]struct{})
+	keys := make(map[string]struct{}, len(arr))
+	for _, g := range arr {
+		for _, t := range g.Targets {
+			discovered[t] = struct{}{}
+		}
+		keys[g.sortKey()] = struct{}{}
+	}
+	collisions := make(map[string]struct{})
+	for _, g := range s.groups {
+		if _, ok := keys[g.sortKey()]; ok {
+			// The group has been loaded from an existing output.
+			continue
+		}
+		targets := make([]string, 0, len(g.Targets))
+		for _, t := range g.Targets {
+			if _, ok := discovered[t]; ok {
+				collisions[t] = struct{}{}
+				if _, ok := s.collisions[t]; !ok {
+					level.Warn(s.logger).Log("msg", "static target already discovered, ignoring it", "target", t)
+				}
+				continue
+			}
+			targets = append(targets, t)
+		}
+		if len(targets) == 0 {
+			continue
+		}
+		arr = append(arr, customSD{Targets: targets, Labels: g.Labels})
+	}
+	s.collisions = collisions
+	return arr
+}
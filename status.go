@@ -0,0 +1,99 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// instanceZones are the zones queried for the servers.
+var instanceZones = []string{"par1", "ams1"}
+
+// zoneStatus is the outcome of the last refreshes of a product in a zone.
+type zoneStatus struct {
+	Product             string     `json:"product"`
+	Zone                string     `json:"zone"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorTime       *time.Time `json:"last_error_time,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	// Found is the number of resources returned by the API at the last success.
+	Found int `json:"found"`
+}
+
+// statusTracker records the outcome of the refreshes per product and zone.
+type statusTracker struct {
+	mtx   sync.Mutex
+	zones map[string]*zoneStatus
+}
+
+// refreshStatus is served by the /status endpoint.
+var refreshStatus = &statusTracker{zones: make(map[string]*zoneStatus)}
+
+func (s *statusTracker) get(product, zone string) *zoneStatus {
+	k := product + "/" + zone
+	z, ok := s.zones[k]
+	if !ok {
+		z = &zoneStatus{Product: product, Zone: zone}
+		s.zones[k] = z
+	}
+	return z
+}
+
+// success records a successful refresh which found n resources.
+func (s *statusTracker) success(product, zone string, n int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	z := s.get(product, zone)
+	now := time.Now()
+	z.LastSuccess = &now
+	z.ConsecutiveFailures = 0
+	z.Found = n
+}
+
+// failure records a failed refresh.
+func (s *statusTracker) failure(product, zone string, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	z := s.get(product, zone)
+	now := time.Now()
+	z.LastError = err.Error()
+	z.LastErrorTime = &now
+	z.ConsecutiveFailures++
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *statusTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	res := make([]zoneStatus, 0, len(s.zones))
+	for _, z := range s.zones {
+		res = append(res, *z)
+	}
+	s.mtx.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Product != res[j].Product {
+			return res[i].Product < res[j].Product
+		}
+		return res[i].Zone < res[j].Zone
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(res)
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/scaleway/go-scaleway/types"
+)
+
+// scrapeHints are the scrape parameters that a server can set in its user_data.
+type scrapeHints struct {
+	Port   int               `json:"port"`
+	Path   string            `json:"path"`
+	Scheme string            `json:"scheme"`
+	Labels map[string]string `json:"labels"`
+}
+
+// getHints returns the scrape hints of the server or nil if it has none.
+func (d *scwDiscoverer) getHints(srv *types.ScalewayServer) *scrapeHints {
+	// The user_data are served by the compute API of the server's zone.
+	data, err := computeGet(d.client, srv.Location.ZoneID, "servers/"+srv.Identifier+"/user_data/"+d.userdataKey)
+	if err == errNotFound {
+		return nil
+	}
+	if err != nil {
+		level.Warn(d.logger).Log("msg", "cannot get the scrape hints", "server", srv.Identifier, "err", err)
+		return nil
+	}
+	var hints scrapeHints
+	if err := json.Unmarshal(data, &hints); err != nil {
+		level.Warn(d.logger).Log("msg", "invalid scrape hints", "server", srv.Identifier, "err", err)
+		return nil
+	}
+	return &hints
+}
+
+// applyHints merges the scrape hints of the server into its target group.
+func (d *scwDiscoverer) applyHints(srv *types.ScalewayServer, tg *targetgroup.Group) {
+	hints := d.getHints(srv)
+	if hints == nil {
+		return
+	}
+	if hints.Port > 0 {
+		addr, err := d.selector.Address(srv, hints.Port)
+		if err != nil {
+			level.Warn(d.logger).Log("msg", "no address for the port of the scrape hints", "server", srv.Identifier, "port", hints.Port, "err", err)
+		} else {
+			tg.Targets[0][model.AddressLabel] = model.LabelValue(addr)
+			tg.Labels[model.AddressLabel] = model.LabelValue(addr)
+		}
+	}
+	if hints.Path != "" {
+		tg.Labels[model.MetricsPathLabel] = model.LabelValue(hints.Path)
+	}
+	switch hints.Scheme {
+	case "":
+	case "http", "https":
+		tg.Labels[model.SchemeLabel] = model.LabelValue(hints.Scheme)
+	default:
+		level.Warn(d.logger).Log("msg", "invalid scheme in scrape hints", "server", srv.Identifier, "scheme", hints.Scheme)
+	}
+	for name, value := range hints.Labels {
+		ln := model.LabelName(name)
+		// The reserved labels can't be overridden.
+		if !ln.IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			level.Warn(d.logger).Log("msg", "invalid label in scrape hints", "server", srv.Identifier, "label", name)
+			continue
+		}
+		tg.Labels[ln] = model.LabelValue(value)
+	}
+}